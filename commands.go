@@ -2,425 +2,742 @@ package main
 
 import (
 	"context"
-	"errors"
+	"flag"
 	"fmt"
 	"io"
-	"log"
-	"net/http"
 	"os"
 	"path/filepath"
-	"runtime"
 	"strings"
+	"time"
 
-	"github.com/minio/minio-go/v7"
+	"ghst.fr/matthew/copy-cat-env/copycat"
 )
 
+// Connection details given to configure, through flags or their COPYCAT_*
+// environment variable equivalents. Anything left empty is prompted for, unless
+// prompting is disabled.
+type configureOptions struct {
+	// Name of the profile being configured.
+	profile string
+
+	backend string
+	host    string
+	key     string
+	secret  string
+	bucket  string
+	region  string
+	path    string
+
+	// Where the credentials are resolved from, see copycat.Options.
+	credentials        string
+	credentialsProfile string
+
+	// Advanced connection settings, only prompted for on request.
+	bucketLookup       string
+	caBundle           string
+	insecureSkipVerify bool
+	proxy              string
+	connectTimeout     string
+	requestTimeout     string
+
+	// Whether missing values are an error rather than prompted for, i.e. when
+	// --yes is given or the secret is read from stdin.
+	noPrompt bool
+}
+
 // Handles setting up the CopyCat environment, prompting to
-// overwrite the existing configuration if previously called. Requires no
-// arguments, nor does it return anything. Will create ~/.config/ directory
-// if it does not exist. Will also create the copycat directory.
-func configure() {
-	fmt.Printf("Setting up COPYCAT Environment\n")
+// overwrite the existing configuration if previously called. Will create
+// the config directory (see copycat.ConfigDir) and its parent if they do not
+// exist.
+//
+// Given an array which may contain the following:
+//   - --backend, --host, --key, --bucket, --region, --path: connection details
+//   - --credentials, --credentials-profile: where credentials are resolved from
+//   - --bucket-lookup, --ca-bundle, --insecure-skip-verify, --proxy,
+//     --connect-timeout, --request-timeout: advanced connection settings
+//   - --secret-stdin: read the secret from stdin
+//   - --keyring: store the secret in the OS keyring rather than the profile
+//   - --yes: overwrite an existing profile, and never prompt
+//
+// the matching details are not prompted for, so profiles can be created without
+// a terminal. Each flag falls back to a COPYCAT_* environment variable (i.e.
+// COPYCAT_HOST or COPYCAT_SECRET).
+func configure(args []string) error {
+	flags := flag.NewFlagSet("configure", flag.ContinueOnError)
+	backend := flags.String("backend", "", "storage backend, s3 or fs (COPYCAT_BACKEND)")
+	host := flags.String("host", "", "hostname of the S3-compliant storage (COPYCAT_HOST)")
+	key := flags.String("key", "", "access key (COPYCAT_KEY)")
+	secretStdin := flags.Bool("secret-stdin", false, "read the secret from stdin (or COPYCAT_SECRET)")
+	bucket := flags.String("bucket", "", "bucket name (COPYCAT_BUCKET)")
+	region := flags.String("region", "", "bucket region (COPYCAT_REGION)")
+	path := flags.String("path", "", "directory used by the fs backend (COPYCAT_PATH)")
+	creds := flags.String("credentials", "", "credentials source: static, env, file, iam or chain (COPYCAT_CREDENTIALS)")
+	credsProfile := flags.String("credentials-profile", "", "profile of the AWS credentials file (COPYCAT_CREDENTIALS_PROFILE)")
+	bucketLookup := flags.String("bucket-lookup", "", "bucket addressing: dns, path or auto (COPYCAT_BUCKET_LOOKUP)")
+	caBundle := flags.String("ca-bundle", "", "PEM file of additional certificate authorities to trust (COPYCAT_CA_BUNDLE)")
+	insecure := flags.Bool("insecure-skip-verify", os.Getenv("COPYCAT_INSECURE_SKIP_VERIFY") == "1", "do not verify TLS certificates (COPYCAT_INSECURE_SKIP_VERIFY=1)")
+	proxy := flags.String("proxy", "", "URL of the HTTP proxy to use (COPYCAT_PROXY)")
+	connectTimeout := flags.String("connect-timeout", "", "how long connecting may take, e.g. 10s (COPYCAT_CONNECT_TIMEOUT)")
+	requestTimeout := flags.String("request-timeout", "", "how long to wait for each response, e.g. 30s (COPYCAT_REQUEST_TIMEOUT)")
+	keyring := flags.Bool("keyring", os.Getenv("COPYCAT_KEYRING") == "1", "store the secret in the OS keyring rather than the profile (COPYCAT_KEYRING=1)")
+	yes := flags.Bool("yes", os.Getenv("COPYCAT_YES") == "1", "overwrite an existing profile, and never prompt (COPYCAT_YES=1)")
+	args, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 0 {
+		return usage("Unexpected argument(s): "+strings.Join(args, " "), false)
+	}
+
+	name, err := activeProfile()
+	if err != nil {
+		return err
+	}
+
+	opts := configureOptions{
+		profile: name,
+
+		backend:  flagOrEnv(*backend, "COPYCAT_BACKEND"),
+		host:     flagOrEnv(*host, "COPYCAT_HOST"),
+		key:      flagOrEnv(*key, "COPYCAT_KEY"),
+		secret:   os.Getenv("COPYCAT_SECRET"),
+		bucket:   flagOrEnv(*bucket, "COPYCAT_BUCKET"),
+		region:   flagOrEnv(*region, "COPYCAT_REGION"),
+		path:     flagOrEnv(*path, "COPYCAT_PATH"),
+		noPrompt: *yes || *secretStdin,
+
+		credentials:        flagOrEnv(*creds, "COPYCAT_CREDENTIALS"),
+		credentialsProfile: flagOrEnv(*credsProfile, "COPYCAT_CREDENTIALS_PROFILE"),
+
+		bucketLookup:       flagOrEnv(*bucketLookup, "COPYCAT_BUCKET_LOOKUP"),
+		caBundle:           flagOrEnv(*caBundle, "COPYCAT_CA_BUNDLE"),
+		insecureSkipVerify: *insecure,
+		proxy:              flagOrEnv(*proxy, "COPYCAT_PROXY"),
+		connectTimeout:     flagOrEnv(*connectTimeout, "COPYCAT_CONNECT_TIMEOUT"),
+		requestTimeout:     flagOrEnv(*requestTimeout, "COPYCAT_REQUEST_TIMEOUT"),
+	}
 
-	// Get user's home directory
-	home, err := os.UserHomeDir()
+	if *secretStdin {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("error reading secret from stdin: %w", err)
+		}
+		opts.secret = strings.TrimRight(string(data), "\r\n")
+	}
+
+	fmt.Printf("Setting up COPYCAT Environment\n")
 
+	dir, err := copycat.ConfigDir()
 	if err != nil {
 		fmt.Println(Fata("A fatal error occurred: "), err)
-		os.Exit(1)
+		return shown(err)
 	}
 
-	// Check if ~/.config folder exists, if not, create it.
-	fmt.Printf("Checking for existing .config folder... ")
+	// Check if the parent folder (i.e. ~/.config) exists, if not, create it.
+	fmt.Printf("Checking for existing %s folder... ", filepath.Dir(dir))
 
-	_, folderErr := os.Stat(home + "/.config/")
-
-	if folderErr != nil {
+	if _, folderErr := os.Stat(filepath.Dir(dir)); folderErr != nil {
 		fmt.Println(Fata("NOT FOUND."))
 
-		fmt.Printf("Creating .config folder (" + home + "/.config/)... ")
-
-		newDir := home + "/.config/"
-		configErr := os.Mkdir(newDir, 0755)
+		fmt.Printf("Creating %s folder... ", filepath.Dir(dir))
 
-		if configErr != nil {
+		if configErr := os.MkdirAll(filepath.Dir(dir), 0755); configErr != nil {
 			fmt.Println(Fata("FAILED"))
 
-			fmt.Println("Could not create .config directory: ", configErr)
-			os.Exit(1)
+			fmt.Println("Could not create config directory: ", configErr)
+			return shown(configErr)
 		}
 
 		fmt.Println(OK("CREATED!"))
-		os.Chmod(newDir, 0755)
 	} else {
 		fmt.Println(OK("FOUND!"))
 	}
 
+	// The copycat folder holds secrets, so only the current user may access it.
 	fmt.Printf("Checking for copycat folder... ")
-	_, folderErr = os.Stat(home + "/.config/copycat/")
+	info, folderErr := os.Stat(dir)
 	if folderErr != nil {
 		fmt.Println(Fata("NOT FOUND."))
-		fmt.Printf("Creating copycat folder (%s/.config/copycat)... ", home)
+		fmt.Printf("Creating copycat folder (%s)... ", dir)
 
-		newDir := home + "/.config/copycat/"
-		configErr := os.Mkdir(newDir, 0644)
+		configErr := os.Mkdir(dir, 0700)
 		if configErr != nil {
-			log.Fatalf("FAILED! See: %s", configErr)
+			fmt.Println(Fata("FAILED!"))
+			return configErr
 		} else {
 			fmt.Println(OK("CREATED!"))
-			os.Chmod(newDir, 0755)
+		}
+	} else if copycat.InsecurePermissions(info.Mode()) {
+		fmt.Println(Warn("FOUND, restricting access to the current user..."))
+		if err = os.Chmod(dir, 0700); err != nil {
+			return err
 		}
 	} else {
 		fmt.Println(OK("FOUND!"))
 	}
 
 	// Check for existing .copycat configuration.
-	fmt.Printf("Checking for existing copycat configuration (profile: %s)... ", os.Getenv("COPYCAT_PROFILE"))
+	fmt.Printf("Checking for existing copycat configuration (profile: %s)... ", opts.profile)
 
-	profileDir := home + "/.config/copycat/" + os.Getenv("COPYCAT_PROFILE")
-	if _, err := os.Stat(profileDir); err == nil {
-		fmt.Println(Warn("EXISTS!"))
-
-		// Ask if they want to overwrite configuration
-		fmt.Print(Info("Delete existing configuration [Y/n]? "))
-		var confirm string
-		fmt.Scanln(&confirm)
+	exists, err := profileExists(opts.profile)
+	if err != nil {
+		fmt.Println(Fata("ERROR?"))
+		return err
+	}
 
-		if confirm == "Y" || confirm == "y" {
-			fmt.Print(Warn("Deleting existing configuration... "))
+	var existing copycat.Profile
+	if exists {
+		fmt.Println(Warn("EXISTS!"))
 
-			// Delete file, so we can overwrite it.
-			if e := os.Remove(profileDir); e != nil {
-				fmt.Println(Fata("FAILED!"))
-			}
+		// Without a terminal to ask on, only overwrite when told to.
+		if opts.noPrompt && !*yes {
+			fmt.Println(Fata("Use ") + Teal("--yes") + Fata(" to overwrite it."))
+			return shown(fmt.Errorf("profile %s: %w", opts.profile, errConflict))
+		}
 
-			fmt.Println(OK("SUCCESS!"))
+		// Ask if they want to overwrite configuration. The existing
+		// configuration is only replaced once the new one is complete, so a
+		// failed run does not lose it.
+		if confirm("Overwrite existing configuration", *yes) {
+			fmt.Println(Warn("Existing configuration will be overwritten."))
 		} else {
 			fmt.Println(Fata("Aborting!"))
-			os.Exit(1)
+			return shown(errCanceled)
 		}
 
-	} else if errors.Is(err, os.ErrNotExist) {
-		fmt.Println(OK("DOES NOT EXIST!"))
+		// Settings which are not connection details are kept.
+		existing, _ = copycat.ReadProfile(opts.profile)
 	} else {
-		fmt.Println(Fata("ERROR?"))
-		log.Fatal(err)
-		os.Exit(1)
+		fmt.Println(OK("DOES NOT EXIST!"))
 	}
 
 	fmt.Println("\nConnection Details:")
 
-	var host string
-	fmt.Print(Info("Hostname (e.g., https://s3.amazonaws.com): "))
-	fmt.Scanln(&host)
+	if opts.backend == "" && !opts.noPrompt {
+		opts.backend = prompt("Backend (s3 or fs) [s3]: ")
+	}
+
+	var profile copycat.Profile
 
-	var username string
-	fmt.Print(Info("KEY: "))
-	fmt.Scanln(&username)
+	switch opts.backend {
+	case "", "s3":
+		profile, err = configureS3(opts)
+	case "fs":
+		profile, err = configureFS(opts)
+	default:
+		fmt.Println(Fata("Unknown backend: ") + opts.backend)
+		return shown(fmt.Errorf("%w: unknown backend %q", errUsage, opts.backend))
+	}
+	if err != nil {
+		return err
+	}
 
-	var password string
-	fmt.Print(Info("SECRET: "))
-	fmt.Scanln(&password)
+	profile.Encryption, profile.Environment = existing.Encryption, existing.Environment
+	profile.NoUpdateCheck = existing.NoUpdateCheck
 
-	var bucket string
-	fmt.Print(Info("BUCKET: "))
-	fmt.Scanln(&bucket)
+	if *keyring && profile.Secret != "" {
+		fmt.Printf("Storing secret in the OS keyring... ")
 
-	fmt.Printf("Attempting to connect... ")
+		if err = copycat.SetKeyringSecret(opts.profile, profile.Secret); err != nil {
+			fmt.Println(Fata("FAILED!"))
+			return err
+		}
 
-	minioClient, err := createClient(host, username, password)
-	if err != nil {
-		log.Println(Fata("FATAL!"))
-		log.Fatalln(err)
-		os.Exit(1)
+		fmt.Println(OK("DONE!"))
+		profile.SecretStore = "keyring"
 	}
-	fmt.Println(OK("DONE!"))
 
-	fmt.Printf("Ensuring \"%s\" bucket exists... ", bucket)
+	fmt.Printf("Saving profile to %s... ", filepath.Join(dir, copycat.ConfigFile))
 
-	if err = ensureBucket(minioClient, bucket); err != nil {
+	if err = copycat.WriteProfile(opts.profile, profile); err != nil {
 		fmt.Println(Fata("FAILED!"))
-		fmt.Println(err)
-		os.Exit(1)
+		return err
 	}
-	fmt.Println(OK("DONE!"))
-
-	fmt.Printf("Creating .copycat config... ")
-
-	createConfig(host, username, password, bucket, profileDir)
 
 	fmt.Println(OK("DONE!"))
 
 	fmt.Println(Info("Configuration created & saved successfully!"))
 
 	fmt.Println("\nRun " + OK("copycat help") + " to see a list of available commands!")
+
+	return emit(map[string]string{"profile": opts.profile, "backend": profile.Backend})
 }
 
-// Returns the environments which have been created.
-// Takes in a single bool "print" which describes whether it will print
-// the environments found as a side-effect.
-func list(print bool) []string {
-	minioClient, bucket, err := getClient()
-	if err != nil {
-		log.Fatalln(err)
-		os.Exit(1)
+// Prompts for the connection details of an S3-compliant bucket (unless given),
+// and ensures the bucket can be reached. Returns the resulting profile.
+// The key and secret are only needed for static credentials.
+func configureS3(opts configureOptions) (copycat.Profile, error) {
+	static := func() bool { return opts.credentials == "" || opts.credentials == "static" }
+	file := func() bool { return opts.credentials == "file" }
+
+	fields := []struct {
+		value    *string
+		label    string
+		flag     string
+		optional bool
+		secret   bool
+		needed   func() bool
+	}{
+		{&opts.host, "Hostname (e.g., https://s3.amazonaws.com): ", "--host", false, false, nil},
+		{&opts.credentials, "CREDENTIALS (static, env, file, iam or chain) [static]: ", "--credentials", true, false, nil},
+		{&opts.key, "KEY: ", "--key", false, false, static},
+		{&opts.secret, "SECRET: ", "--secret-stdin", false, true, static},
+		{&opts.credentialsProfile, "AWS credentials profile (optional): ", "--credentials-profile", true, false, file},
+		{&opts.bucket, "BUCKET: ", "--bucket", false, false, nil},
+		{&opts.region, "REGION (optional): ", "--region", true, false, nil},
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-
-	defer cancel()
+	for _, field := range fields {
+		if field.needed != nil && !field.needed() {
+			continue
+		}
+		if *field.value != "" || (opts.noPrompt && field.optional) {
+			continue
+		}
+		if opts.noPrompt {
+			return copycat.Profile{}, usage("Missing "+field.flag, false)
+		}
+		if field.secret {
+			*field.value = promptSecret(field.label)
+		} else {
+			*field.value = prompt(field.label)
+		}
+	}
 
-	objectCh := minioClient.ListObjects(ctx, bucket, minio.ListObjectsOptions{
-		Prefix:    "env_",
-		Recursive: false,
-	})
+	advanced := []struct {
+		value *string
+		label string
+	}{
+		{&opts.bucketLookup, "Bucket lookup (dns, path or auto) [auto]: "},
+		{&opts.caBundle, "CA bundle (optional): "},
+		{&opts.proxy, "Proxy URL (optional): "},
+		{&opts.connectTimeout, "Connect timeout (e.g., 10s, optional): "},
+		{&opts.requestTimeout, "Request timeout (e.g., 30s, optional): "},
+	}
 
-	if print {
-		fmt.Println(White("Environments:"))
+	if !opts.noPrompt && confirm("Configure advanced connection settings (bucket lookup, CA bundle, proxy, timeouts)", false) {
+		for _, field := range advanced {
+			if *field.value == "" {
+				*field.value = prompt(field.label)
+			}
+		}
+		if !opts.insecureSkipVerify {
+			opts.insecureSkipVerify = confirm("Skip TLS certificate verification", false)
+		}
 	}
 
-	var env []string
+	profile := copycat.Profile{
+		Backend: "s3",
+		Host:    opts.host,
+		Bucket:  opts.bucket,
+		Region:  opts.region,
 
-	if len(objectCh) == 0 {
-		fmt.Println("... " + Warn("Empty!"))
-		return env
+		CredentialsProfile: opts.credentialsProfile,
+
+		BucketLookup:       opts.bucketLookup,
+		CABundle:           opts.caBundle,
+		InsecureSkipVerify: opts.insecureSkipVerify,
+		Proxy:              opts.proxy,
+	}
+	if static() {
+		profile.Key, profile.Secret = opts.key, opts.secret
+	} else {
+		profile.Credentials = opts.credentials
 	}
 
-	for object := range objectCh {
-		if object.Err != nil {
-			fmt.Println(object.Err)
-			return env
+	for _, timeout := range []struct {
+		value  string
+		flag   string
+		target *time.Duration
+	}{
+		{opts.connectTimeout, "--connect-timeout", &profile.ConnectTimeout},
+		{opts.requestTimeout, "--request-timeout", &profile.RequestTimeout},
+	} {
+		if timeout.value == "" {
+			continue
 		}
-		if print {
-			fmt.Println(Teal(strings.Replace(object.Key, "env_", "", 1)))
+
+		var err error
+		if *timeout.target, err = time.ParseDuration(timeout.value); err != nil {
+			return copycat.Profile{}, usage("Invalid "+timeout.flag+", expected a duration such as 30s", false)
 		}
-		env = append(env, strings.Replace(object.Key, "env_", "", 1))
 	}
 
-	return env
-}
+	fmt.Printf("Attempting to connect... ")
 
-// Given an environment (key), fetches the .env corresponding to that
-// environment and downloads it as ".env". Terminates program if environment
-// doesn't exist.
-func download(key string) {
-	minioClient, bucket, err := getClient()
+	clientOpts, err := copycat.ProfileOptions(opts.profile, profile)
 	if err != nil {
-		log.Fatalln(err)
-		os.Exit(1)
+		fmt.Println(Fata("FATAL!"))
+		return copycat.Profile{}, err
 	}
 
-	ensureBucket(minioClient, bucket)
+	minioClient, err := copycat.NewS3Client(clientOpts)
+	if err != nil {
+		fmt.Println(Fata("FATAL!"))
+		return copycat.Profile{}, err
+	}
+	fmt.Println(OK("DONE!"))
 
-	fmt.Print(Teal("Downloading " + key + " environment as .env... "))
+	fmt.Printf("Ensuring \"%s\" bucket exists... ", opts.bucket)
 
-	object, err := minioClient.GetObject(context.Background(), bucket, "env_"+key, minio.GetObjectOptions{})
-	if err != nil {
+	if err = ensureBucket(minioClient, opts.bucket); err != nil {
 		fmt.Println(Fata("FAILED!"))
 		fmt.Println(err)
-		return
+		return copycat.Profile{}, shown(err)
+	}
+	fmt.Println(OK("DONE!"))
+
+	return profile, nil
+}
+
+// Prompts for the directory used by the fs backend (unless given), creating it
+// if needed. Returns the resulting profile.
+func configureFS(opts configureOptions) (copycat.Profile, error) {
+	if opts.path == "" {
+		if opts.noPrompt {
+			return copycat.Profile{}, usage("Missing --path", false)
+		}
+		opts.path = prompt("PATH (e.g., /mnt/nas/copycat): ")
 	}
-	localFile, err := os.Create("./.env")
+
+	path, err := filepath.Abs(opts.path)
 	if err != nil {
-		fmt.Println(Fata("FAILED!"))
-		fmt.Println(err)
-		return
+		fmt.Println(Fata("Invalid path: "), err)
+		return copycat.Profile{}, shown(err)
 	}
-	if _, err = io.Copy(localFile, object); err != nil {
+
+	fmt.Printf("Ensuring \"%s\" exists... ", path)
+
+	if err = os.MkdirAll(path, 0755); err != nil {
 		fmt.Println(Fata("FAILED!"))
 		fmt.Println(err)
-		return
+		return copycat.Profile{}, shown(err)
 	}
-
 	fmt.Println(OK("DONE!"))
+
+	return copycat.Profile{Backend: "fs", Path: path}, nil
 }
 
-// Creates a new environment and uploads the corresponding ".env" file.
-func upload(key string) {
-	minioClient, bucket, err := getClient()
+// Returns the environments which have been created.
+// Takes in a single bool "print" which describes whether it will print
+// the environments found as a side-effect.
+func list(print bool) ([]string, error) {
+	client, err := getClient()
 	if err != nil {
-		log.Fatalln(err)
-		os.Exit(1)
+		return nil, err
 	}
 
-	ensureBucket(minioClient, bucket)
-
-	fmt.Print(Teal("Uploading .env with key " + key + "... "))
-
-	objectName := "env_" + key
-	filePath := "./.env"
-	contentType := "text/plain"
-
-	// Upload the env file.
-	err = uploadFile(minioClient, objectName, filePath, contentType, bucket)
+	environments, err := client.ListEnvironments(context.Background())
 	if err != nil {
-		fmt.Println(Fata("FAILED!"))
-		log.Fatalln(err)
+		return nil, err
 	}
 
-	fmt.Println(OK("DONE!"))
-}
+	var env []string
+	for _, environment := range environments {
+		env = append(env, environment.Name)
+	}
 
-// Prints all of CopyCat's functions to standard output.
-func help(files bool) {
-	if !files {
-		fmt.Println(White("CopyCat Client\n"))
-		fmt.Println("Usage: copycat [--profile <name>] <command>")
-		fmt.Println("Commands:")
-		fmt.Println("	help")
-		fmt.Println("	list")
-		fmt.Println("	download <environment>")
-		fmt.Println("	upload <environment>")
-		fmt.Println("	files help")
-
-		fmt.Println("	")
-	} else {
-		fmt.Println(Teal("CopyCat File System"))
-		fmt.Println("Usage: copycat [--profile] files <command>")
-		fmt.Println("Commands:")
-		fmt.Println("	help")
-		fmt.Println("	list")
-		fmt.Println("	<environment> list")
-		fmt.Println("	<environment> upload <file name> [upload name]")
-		fmt.Println("	<environment> download <file name> [download name]")
+	if !print {
+		return env, nil
 	}
-}
 
-// Handles main update routine, which involves checking if a newer version
-// has been released, and replacing the CopyCat binary.
-func update() {
-	fmt.Print("Checking if update exists... ")
+	if jsonOutput() {
+		return env, emit(map[string][]copycat.Environment{"environments": environments})
+	}
 
-	// Check if update exists to begin with.
-	ver, err := getVersion()
+	fmt.Println(White("Environments:"))
 
-	if err != nil {
-		fmt.Println(Fata("FAILED!"))
-		log.Fatal(err)
-		os.Exit(1)
+	if len(env) == 0 {
+		fmt.Println("... " + Warn("Empty!"))
 	}
 
-	if ver == version {
-		fmt.Println(Teal("NONE!"))
-		fmt.Println(Warn("You already have the latest version: ") + OK(ver))
-		os.Exit(0)
+	for _, name := range env {
+		fmt.Println(Teal(name))
 	}
 
-	fmt.Println(OK("FOUND! ") + Fata(version) + " -> " + OK(ver))
+	return env, nil
+}
 
-	// Get path of where executable is installed.
-	fmt.Printf("Locating installation location... ")
+// Describes a transfer between the local disk and the store in JSON output.
+type transferInfo struct {
+	Environment string             `json:"environment"`
+	Path        string             `json:"path"`
+	Object      copycat.ObjectInfo `json:"object"`
+}
 
-	ex, err := os.Executable()
+// Writes the result of a transfer as a JSON document, once the object is
+// stored under objectName.
+func emitTransfer(client *copycat.Client, env string, path string, objectName string) error {
+	if !jsonOutput() {
+		return nil
+	}
 
+	info, err := client.Store().Stat(context.Background(), objectName)
 	if err != nil {
-		fmt.Println(Fata("FAILED!"))
-		log.Fatal(err)
-		os.Exit(1)
+		return err
 	}
 
-	fmt.Println(OK("FOUND!"))
-	installDir := filepath.Dir(ex)
+	return emit(transferInfo{Environment: env, Path: path, Object: info})
+}
 
-	// Confirm installation directory with user
-	var confirm string
-	fmt.Print("Confirm installation directory (" + installDir + ") [Y/n]: ")
-	fmt.Scanln(&confirm)
+// Given an array which may contain the following:
+//   - 0: environment, the profile's default environment if omitted
+//   - --file: where to write the environment, "-" for stdout
+//
+// fetches the .env corresponding to that environment and downloads it as
+// ".env" (or the given file). Returns an error if the environment doesn't exist.
+func download(args []string) error {
+	flags := flag.NewFlagSet("download", flag.ContinueOnError)
+	file := flags.String("file", ".env", "file to write the environment to, - for stdout")
+
+	args, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
 
-	if confirm != "Y" && confirm != "y" {
-		fmt.Println(Fata("Aborting!"))
-		os.Exit(1)
+	env, err := environmentArg(args)
+	if err != nil {
+		return err
 	}
 
-	// Download latest version of copycat
-	url := os.Getenv("VERSION_HOST") + "copycat-" + runtime.GOOS + "-" + runtime.GOARCH
+	return downloadEnv(env, *file)
+}
 
-	fmt.Print(Teal("Attempting to create temporary file... "))
+// Downloads an environment (key) to path, or to stdout if path is "-".
+func downloadEnv(key string, path string) error {
+	if path == "-" {
+		if jsonOutput() {
+			return usage("--file - cannot be used with -output json, as both are written to stdout", false)
+		}
 
-	// Store the download in a temporary directory
-	dir := os.TempDir() + "/copycat"
-	file, err := os.Create(dir)
+		// Stdout only receives the environment, progress messages go to stderr.
+		stdout := os.Stdout
+		os.Stdout = os.Stderr
+		defer func() { os.Stdout = stdout }()
 
-	handleError(err, true, true)
+		return downloadTo(key, path, func(data []byte) error {
+			_, err := stdout.Write(data)
+			return err
+		})
+	}
 
-	fmt.Println(OK("CREATED!"))
-	defer file.Close()
+	return downloadTo(key, path, func(data []byte) error {
+		return os.WriteFile(path, data, 0644)
+	})
+}
 
-	// Download the actual binary
-	fmt.Print(Teal("Fetching latest release from " + url + "... "))
-	resp, err := http.Get(url)
+// Fetches an environment (key), and writes it using write. path is only
+// reported.
+func downloadTo(key string, path string, write func(data []byte) error) error {
+	client, err := getClient()
+	if err != nil {
+		return err
+	}
 
-	handleError(err, true, true)
-	defer resp.Body.Close()
+	target := path
+	if path == "-" {
+		target = "stdout"
+	}
 
-	// Write the downloaded binary to the temporary file
-	n, err := io.Copy(file, resp.Body)
+	fmt.Print(Teal("Downloading " + key + " environment to " + target + "... "))
 
-	handleError(err, true, true)
+	data, err := client.GetEnvironment(context.Background(), key)
+	if err == nil {
+		err = write(data)
+	}
+	if err != nil {
+		fmt.Println(Fata("FAILED!"))
+		return err
+	}
 
 	fmt.Println(OK("DONE!"))
-	fmt.Printf(Teal("Wrote %d bytes to %s\n"), n, dir)
 
-	// With the new binary installed, attempt to replace the currently running binary with the
-	// new downloaded binary.
-	fmt.Print("Attempting to overwrite binary at " + Info(installDir) + " with binary " + Info(dir) + "... ")
+	return emitTransfer(client, key, path, "env_"+key)
+}
 
-	// Rename current binary
-	moveErr := os.Rename(installDir+"/copycat", installDir+"/old_copycat")
-	handleError(moveErr, true, true)
+// Given an array which may contain the following:
+//   - 0: environment, the profile's default environment if omitted
+//   - --file: file to upload, "-" for stdin
+//
+// creates a new environment and uploads the corresponding ".env" file (or the
+// given one). The version being overwritten is kept in the environment's
+// history.
+func upload(args []string) error {
+	flags := flag.NewFlagSet("upload", flag.ContinueOnError)
+	file := flags.String("file", ".env", "file to upload, - for stdin")
+
+	args, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+
+	env, err := environmentArg(args)
+	if err != nil {
+		return err
+	}
 
-	toReplace, errR := os.OpenFile(installDir+"/copycat", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0755)
-	toInstall, errI := os.ReadFile(dir)
+	return uploadEnv(env, *file)
+}
 
-	// If any error occurs, we need to restore the old binary.
-	if errR != nil || errI != nil {
-		_ = os.Rename(installDir+"/old_copycat", installDir+"/copycat")
+// Uploads the file at path (or stdin, if path is "-") as an environment (key).
+func uploadEnv(key string, path string) error {
+	// Read before creating the client, so a passphrase prompt never consumes
+	// stdin. It is then prompted for on the terminal instead.
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+		stdinConsumed = true
+	} else {
+		data, err = os.ReadFile(path)
 	}
 
-	handleError(errR, true, true)
-	handleError(errI, true, true)
+	client, clientErr := getClient()
+	if clientErr != nil {
+		return clientErr
+	}
 
-	defer toReplace.Close()
+	source := path
+	if path == "-" {
+		source = "stdin"
+	}
 
-	// Write the new code to the binary
-	bWritten, err := toReplace.Write(toInstall)
+	fmt.Print(Teal("Uploading " + source + " with key " + key + "... "))
 
-	// Similarly, if we can't write to the new binary, we need to replace the old one.
+	if err == nil {
+		err = client.PutEnvironment(context.Background(), key, data)
+	}
 	if err != nil {
-		_ = os.Rename(installDir+"/old_copycat", installDir+"/copycat")
-		handleError(err, true, true)
+		fmt.Println(Fata("FAILED!"))
+		return err
 	}
 
-	// Clean up any temporary files, or old binaries.
-	_ = os.Remove(installDir + "/old_copycat")
-	_ = os.Remove(dir)
-
 	fmt.Println(OK("DONE!"))
-	fmt.Printf(Info("Successfully wrote %d bytes to %s/copycat!\n"), bWritten, installDir)
+
+	return emitTransfer(client, key, path, "env_"+key)
+}
+
+// Prints the usage of CopyCat's functions, or of those of a group of commands
+// (topic, i.e. "files"), to standard output.
+func help(topic string) {
+	title, text := helpText(topic)
+	if topic == "" {
+		fmt.Println(White(title + "\n"))
+	} else {
+		fmt.Println(Teal(title))
+	}
+	fmt.Print(text)
+}
+
+// Prints the help message as the result of a help command. In JSON mode, it
+// is written as a document of its own, like any other result.
+func showHelp(topic string) error {
+	if jsonOutput() {
+		title, text := helpText(topic)
+		return emit(map[string]string{"help": title + "\n\n" + text})
+	}
+
+	help(topic)
+
+	return nil
+}
+
+// Returns the title and usage of CopyCat's functions, or of those of a group of
+// commands, without any color.
+func helpText(topic string) (string, string) {
+	var b strings.Builder
+
+	switch topic {
+	case "files":
+		fmt.Fprintln(&b, "Usage: copycat [--profile] files <command>")
+		fmt.Fprintln(&b, "Commands:")
+		fmt.Fprintln(&b, "	help")
+		fmt.Fprintln(&b, "	list")
+		fmt.Fprintln(&b, "	<environment> list")
+		fmt.Fprintln(&b, "	<environment> upload <file name> [upload name] [--force]")
+		fmt.Fprintln(&b, "	<environment> download <file name> [download name] [--force]")
+		fmt.Fprintln(&b, "	<environment> delete <file name>")
+		fmt.Fprintln(&b, "	<environment> move <file name> <new name> [--force]")
+
+		return "CopyCat File System", b.String()
+	case "keys":
+		fmt.Fprintln(&b, "Usage: copycat [--profile <name>] keys <command>")
+		fmt.Fprintln(&b, "Commands:")
+		fmt.Fprintln(&b, "	generate")
+		fmt.Fprintln(&b, "	list <environment>")
+		fmt.Fprintln(&b, "	add <environment> <public key>")
+		fmt.Fprintln(&b, "	remove <environment> <public key>")
+		fmt.Fprintln(&b, "	trust <environment> [--yes]")
+
+		return "CopyCat Keys", b.String()
+	case "profile":
+		fmt.Fprintln(&b, "Usage: copycat profile <command>")
+		fmt.Fprintln(&b, "Commands:")
+		fmt.Fprintln(&b, "	list")
+		fmt.Fprintln(&b, "	show [name]")
+		fmt.Fprintln(&b, "	use <name>")
+		fmt.Fprintln(&b, "	copy <name> <new name>")
+		fmt.Fprintln(&b, "	rename <name> <new name>")
+		fmt.Fprintln(&b, "	delete <name> [--yes]")
+
+		return "CopyCat Profiles", b.String()
+	}
+
+	fmt.Fprintln(&b, "Usage: copycat [--profile <name>] [--config-dir <dir>] [--encrypt] [--key-file <path>] [--output json] <command>")
+	fmt.Fprintln(&b, "Commands:")
+	fmt.Fprintln(&b, "	help")
+	fmt.Fprintln(&b, "	configure [--backend s3|fs] [--host <url>] [--key <key>] [--secret-stdin] [--bucket <name>] [--region <region>] [--path <dir>] [--credentials <source>] [--bucket-lookup dns|path|auto] [--ca-bundle <pem>] [--insecure-skip-verify] [--proxy <url>] [--connect-timeout <duration>] [--request-timeout <duration>] [--keyring] [--yes]")
+	fmt.Fprintln(&b, "	list")
+	fmt.Fprintln(&b, "	download [environment] [--file <path>|-]")
+	fmt.Fprintln(&b, "	upload [environment] [--file <path>|-]")
+	fmt.Fprintln(&b, "	pull [environment] [--force]")
+	fmt.Fprintln(&b, "	push [environment] [--force]")
+	fmt.Fprintln(&b, "	delete <environment> [--yes]")
+	fmt.Fprintln(&b, "	rename <environment> <new name> [--yes]")
+	fmt.Fprintln(&b, "	copy <environment> <new name> [--yes]")
+	fmt.Fprintln(&b, "	diff <environment> [other] [--show-values]")
+	fmt.Fprintln(&b, "	run <environment> -- <cmd> [args...]")
+	fmt.Fprintln(&b, "	history [environment]")
+	fmt.Fprintln(&b, "	rollback <environment> <version>")
+	fmt.Fprintln(&b, "	files help")
+	fmt.Fprintln(&b, "	keys help")
+	fmt.Fprintln(&b, "	profile help")
+	fmt.Fprintln(&b, "	update [--channel stable|beta] [--to <version>] [--check] [--yes]")
+
+	fmt.Fprintln(&b, "	")
+
+	return "CopyCat Client", b.String()
 }
 
 // Deletes the specified configuration.
-func reset() {
-	config, configExists := configExists(os.Getenv("COPYCAT_PROFILE"))
-	if !configExists {
+func reset() error {
+	name, err := activeProfile()
+	if err != nil {
+		return err
+	}
+
+	exists, err := profileExists(name)
+	if err != nil {
+		return err
+	}
+	if !exists {
 		fmt.Println(Fata("Configuration does not exist."))
 		fmt.Println("Use " + Info("copycat configure") + " to set up your configuration.")
-		os.Exit(1)
+		return shown(fmt.Errorf("profile %s: %w", name, errNotFound))
 	}
 
 	// Prompt for user confirmation
-	var prompt string
 	fmt.Print(Warn("Are you sure you want to delete your configuration [Y/n]? "))
-	fmt.Scanln(&prompt)
 
-	if prompt == "N" || prompt == "n" {
-		log.Fatalln(Fata("Canceled"))
+	if answer := readLine(); answer == "N" || answer == "n" {
+		fmt.Println(Fata("Canceled"))
+		return shown(errCanceled)
 	}
 
-	// Delete the config file
-	err := os.Remove(config)
-	if err != nil {
-		log.Fatalf("Failed to delete .copycat; see: %s\n", err)
+	if err = copycat.DeleteProfile(name); err != nil {
+		return err
 	}
 	fmt.Println(OK("Permanently deleted configuration file."))
+
+	return emit(map[string]string{"profile": name})
 }
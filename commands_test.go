@@ -0,0 +1,401 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"ghst.fr/matthew/copy-cat-env/copycat"
+	"github.com/zalando/go-keyring"
+)
+
+// Name of the bucket every fakeS3-backed profile uses.
+const testBucket = "copycat"
+
+// Points HOME at a temporary directory, writes the given settings as the
+// "default" profile and moves into an empty working directory, which is
+// returned.
+func setupProfile(t *testing.T, profile copycat.Profile) string {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("COPYCAT_CONFIG_DIR", "")
+	t.Setenv("COPYCAT_PROFILE", "default")
+
+	if err := copycat.WriteProfile("default", profile); err != nil {
+		t.Fatal(err)
+	}
+
+	return chdir(t, t.TempDir())
+}
+
+// Profile setups every end-to-end test is run against.
+var testBackends = map[string]func(t *testing.T) string{
+	"s3": func(t *testing.T) string {
+		fake := newFakeS3(t, testBucket)
+		return setupProfile(t, copycat.Profile{
+			Backend: "s3",
+			Host:    fake.URL,
+			Key:     "key",
+			Secret:  "secret",
+			Bucket:  testBucket,
+		})
+	},
+	"fs": func(t *testing.T) string {
+		return setupProfile(t, copycat.Profile{
+			Backend: "fs",
+			Path:    t.TempDir(),
+		})
+	},
+}
+
+// Changes the working directory for the duration of the test.
+func chdir(t *testing.T, dir string) string {
+	previous, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(previous) })
+
+	return dir
+}
+
+// Runs fn with stdin fed from input, returning everything written to stdout.
+// Fails the test if fn returns an error.
+func captureOutput(t *testing.T, input string, fn func() error) string {
+	output, err := captureError(t, input, fn)
+	if err != nil {
+		t.Errorf("Unexpected error: %v\n%s", err, output)
+	}
+
+	return output
+}
+
+// Runs fn with stdin fed from input, returning everything written to stdout
+// alongside the error fn returned.
+func captureError(t *testing.T, input string, fn func() error) (string, error) {
+	stdin, stdout := os.Stdin, os.Stdout
+	defer func() { os.Stdin, os.Stdout, stdinConsumed = stdin, stdout, false }()
+
+	inR, inW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		io.WriteString(inW, input)
+		inW.Close()
+	}()
+
+	output := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(outR)
+		output <- string(data)
+	}()
+
+	os.Stdin, os.Stdout = inR, outW
+	err = fn()
+	outW.Close()
+	inR.Close()
+
+	return <-output, err
+}
+
+func writeFile(t *testing.T, path string, data string) {
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestUploadDownload(t *testing.T) {
+	for name, setup := range testBackends {
+		t.Run(name, func(t *testing.T) {
+			setup(t)
+
+			env := "KEY=value\nSECRET=hunter2\n"
+			writeFile(t, ".env", env)
+
+			captureOutput(t, "", func() error { return upload([]string{"staging"}) })
+
+			var envs []string
+			output := captureOutput(t, "", func() (err error) { envs, err = list(true); return })
+			if !reflect.DeepEqual(envs, []string{"staging"}) || !strings.Contains(output, "staging") {
+				t.Errorf("Unexpected environments: %v\n%s", envs, output)
+			}
+
+			os.Remove(".env")
+			captureOutput(t, "", func() error { return download([]string{"staging"}) })
+
+			if got := readFile(t, ".env"); got != env {
+				t.Errorf("Downloaded .env does not match: %q", got)
+			}
+		})
+	}
+}
+
+func TestUploadDownloadFile(t *testing.T) {
+	for name, setup := range testBackends {
+		t.Run(name, func(t *testing.T) {
+			setup(t)
+
+			env := "KEY=value\n"
+			os.MkdirAll(filepath.Join("apps", "api"), 0755)
+			writeFile(t, filepath.Join("apps", "api", ".env"), env)
+
+			captureOutput(t, "", func() error { return upload([]string{"api", "--file", "apps/api/.env"}) })
+			captureOutput(t, "", func() error { return download([]string{"--file", ".env.local", "api"}) })
+
+			if got := readFile(t, ".env.local"); got != env {
+				t.Errorf("Downloaded file does not match: %q", got)
+			}
+			if _, err := os.Stat(".env"); err == nil {
+				t.Errorf("Expected ./.env to be left alone")
+			}
+
+			// Piped through stdin and stdout, without any progress message.
+			captureOutput(t, "OTHER=1\n", func() error { return upload([]string{"api", "--file", "-"}) })
+			if output := captureOutput(t, "", func() error { return download([]string{"api", "--file", "-"}) }); output != "OTHER=1\n" {
+				t.Errorf("Expected only the environment on stdout, got %q", output)
+			}
+		})
+	}
+}
+
+func TestFiles(t *testing.T) {
+	for name, setup := range testBackends {
+		t.Run(name, func(t *testing.T) {
+			setup(t)
+
+			writeFile(t, ".env", "KEY=value\n")
+			writeFile(t, "secrets.txt", "aws secrets")
+			captureOutput(t, "", func() error { return upload([]string{"staging"}) })
+
+			captureOutput(t, "", func() error { return files([]string{"staging", "upload", "secrets.txt", "aws_secrets.txt"}) })
+
+			output := captureOutput(t, "", func() error { return files([]string{"staging", "list"}) })
+			if !strings.Contains(output, "aws_secrets.txt") {
+				t.Errorf("Uploaded file not listed:\n%s", output)
+			}
+
+			captureOutput(t, "", func() error { return files([]string{"staging", "download", "aws_secrets.txt", "new_secrets.txt"}) })
+
+			if got := readFile(t, "new_secrets.txt"); got != "aws secrets" {
+				t.Errorf("Downloaded file does not match: %q", got)
+			}
+
+			// Overwriting requires --force, both remotely and locally.
+			writeFile(t, "secrets.txt", "new aws secrets")
+			captureOutput(t, "", func() error { return files([]string{"staging", "upload", "secrets.txt", "aws_secrets.txt", "--force"}) })
+			captureOutput(t, "", func() error {
+				return files([]string{"staging", "download", "--force", "aws_secrets.txt", "new_secrets.txt"})
+			})
+
+			if got := readFile(t, "new_secrets.txt"); got != "new aws secrets" {
+				t.Errorf("File was not overwritten: %q", got)
+			}
+
+			captureOutput(t, "", func() error { return files([]string{"staging", "move", "aws_secrets.txt", "moved.txt"}) })
+			output = captureOutput(t, "", func() error { return files([]string{"staging", "list"}) })
+			if !strings.Contains(output, "moved.txt") || strings.Contains(output, "aws_secrets.txt") {
+				t.Errorf("File was not moved:\n%s", output)
+			}
+
+			captureOutput(t, "", func() error { return files([]string{"staging", "delete", "moved.txt"}) })
+			output = captureOutput(t, "", func() error { return files([]string{"staging", "list"}) })
+			if strings.Contains(output, "moved.txt") {
+				t.Errorf("File was not deleted:\n%s", output)
+			}
+		})
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	fake := newFakeS3(t, testBucket)
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("COPYCAT_CONFIG_DIR", "")
+	t.Setenv("COPYCAT_PROFILE", "default")
+
+	input := strings.Join([]string{"s3", fake.URL, "", "key", "secret", testBucket}, "\n") + "\n"
+	output := captureOutput(t, input, func() error { return configure(nil) })
+
+	if !strings.Contains(output, "Configuration created & saved successfully!") {
+		t.Fatalf("Configure did not succeed:\n%s", output)
+	}
+
+	profile, err := copycat.ReadProfile("default")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := copycat.Profile{
+		Backend: "s3",
+		Host:    fake.URL,
+		Key:     "key",
+		Secret:  "secret",
+		Bucket:  testBucket,
+	}
+	if !reflect.DeepEqual(profile, expected) {
+		t.Errorf("Unexpected profile: %+v", profile)
+	}
+
+	// The new profile should be usable straight away.
+	chdir(t, t.TempDir())
+	writeFile(t, ".env", "KEY=value\n")
+	captureOutput(t, "", func() error { return upload([]string{"configured"}) })
+
+	if data, ok := fake.object(testBucket, "env_configured"); !ok || string(data) != "KEY=value\n" {
+		t.Errorf("Environment was not uploaded to the configured bucket")
+	}
+}
+
+func TestConfigureNonInteractive(t *testing.T) {
+	fake := newFakeS3(t, testBucket)
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("COPYCAT_CONFIG_DIR", "")
+	t.Setenv("COPYCAT_PROFILE", "default")
+	t.Setenv("COPYCAT_BUCKET", testBucket)
+
+	args := []string{"--host", fake.URL, "--key", "key", "--region", "eu-west-1", "--secret-stdin"}
+	captureOutput(t, "a secret with spaces\n", func() error { return configure(args) })
+
+	profile, err := copycat.ReadProfile("default")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := copycat.Profile{
+		Backend: "s3",
+		Host:    fake.URL,
+		Key:     "key",
+		Secret:  "a secret with spaces",
+		Bucket:  testBucket,
+		Region:  "eu-west-1",
+	}
+	if !reflect.DeepEqual(profile, expected) {
+		t.Errorf("Unexpected profile: %+v", profile)
+	}
+
+	// Existing profiles are only overwritten with --yes.
+	_, err = captureError(t, "other secret\n", func() error { return configure(args) })
+	if !errors.Is(err, errConflict) {
+		t.Errorf("Expected a conflict, got %v", err)
+	}
+
+	// Missing values are an error rather than prompted for.
+	t.Setenv("COPYCAT_SECRET", "secret")
+	_, err = captureError(t, "", func() error { return configure([]string{"--yes", "--key", "key"}) })
+	if !errors.Is(err, errUsage) {
+		t.Errorf("Expected a usage error, got %v", err)
+	}
+
+	// Prompted values containing spaces are kept whole.
+	path := filepath.Join(t.TempDir(), "shared drive")
+	captureOutput(t, "", func() error { return configure([]string{"--yes", "--backend", "fs", "--path", path}) })
+	profile, _ = copycat.ReadProfile("default")
+	if profile.Path != path {
+		t.Errorf("Unexpected path: %q", profile.Path)
+	}
+
+	captureOutput(t, "y\nfs\n"+path+" 2\n", func() error { return configure(nil) })
+	profile, _ = copycat.ReadProfile("default")
+	if profile.Path != path+" 2" {
+		t.Errorf("Prompted path was truncated: %q", profile.Path)
+	}
+
+	// With a config dir of its own, the home directory is never written to.
+	if err := os.Chmod(home, 0500); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chmod(home, 0700) })
+
+	config := filepath.Join(t.TempDir(), "project", "copycat")
+	t.Setenv("COPYCAT_CONFIG_DIR", config)
+	captureOutput(t, "", func() error { return configure([]string{"--yes", "--backend", "fs", "--path", path}) })
+	if _, err = os.Stat(filepath.Join(config, copycat.ConfigFile)); err != nil {
+		t.Errorf("Profile was not written to the config dir: %v", err)
+	}
+}
+
+func TestConfigurePermissions(t *testing.T) {
+	fake := newFakeS3(t, testBucket)
+	keyring.MockInit()
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("COPYCAT_CONFIG_DIR", "")
+	t.Setenv("COPYCAT_PROFILE", "default")
+	chdir(t, t.TempDir())
+
+	args := []string{"--host", fake.URL, "--key", "key", "--bucket", testBucket, "--secret-stdin"}
+	captureOutput(t, "secret\n", func() error { return configure(args) })
+
+	dir := filepath.Join(home, ".config", "copycat")
+	config := filepath.Join(dir, copycat.ConfigFile)
+	for path, mode := range map[string]os.FileMode{dir: 0700, config: 0600} {
+		if info, err := os.Stat(path); err != nil || info.Mode().Perm() != mode {
+			t.Errorf("Expected %s to have mode %04o, got %v (%v)", path, mode, info.Mode().Perm(), err)
+		}
+	}
+
+	// Profiles holding a secret others can read are refused.
+	os.Chmod(config, 0644)
+	writeFile(t, ".env", "KEY=value\n")
+	output, err := captureError(t, "", func() error { return upload([]string{"staging"}) })
+	if !errors.Is(err, copycat.ErrInsecureProfile) || !strings.Contains(output, "chmod 600") {
+		t.Errorf("Expected the profile to be refused, got %v\n%s", err, output)
+	}
+
+	// Loose folders only warrant a warning.
+	os.Chmod(config, 0600)
+	os.Chmod(dir, 0755)
+	if output := captureOutput(t, "", func() error { return upload([]string{"staging"}) }); !strings.Contains(output, "chmod 700") {
+		t.Errorf("Expected a warning about the folder:\n%s", output)
+	}
+
+	// With --keyring, the secret never touches the profile.
+	captureOutput(t, "secret\n", func() error { return configure(append(args, "--keyring", "--yes")) })
+
+	data, err := os.ReadFile(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret:") || !strings.Contains(string(data), "secret_store: keyring") {
+		t.Errorf("Unexpected profile:\n%s", data)
+	}
+	if secret, err := keyring.Get(copycat.KeyringService, "default"); err != nil || secret != "secret" {
+		t.Errorf("Secret was not stored in the keyring: %q (%v)", secret, err)
+	}
+
+	captureOutput(t, "", func() error { return upload([]string{"staging"}) })
+
+	captureOutput(t, "y\n", reset)
+	if _, err := keyring.Get(copycat.KeyringService, "default"); !errors.Is(err, keyring.ErrNotFound) {
+		t.Errorf("Secret was not removed from the keyring: %v", err)
+	}
+}
@@ -0,0 +1,238 @@
+/*
+Package copycat stores ".env" files (environments), and the files uploaded
+alongside them, in any S3-compliant bucket or a plain directory. It is the
+library the copycat command is built on.
+
+A Client is created either from a profile written by "copycat configure":
+
+	client, err := copycat.NewFromProfile("default")
+
+or from explicit options:
+
+	client, err := copycat.New(copycat.Options{
+		Host:   "https://s3.amazonaws.com",
+		Key:    "...",
+		Secret: "...",
+		Bucket: "copycat",
+	})
+
+Every method takes a context, and returns an error wrapping ErrNotFound or
+ErrConflict where relevant.
+*/
+package copycat
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Options describe the storage a Client talks to, and how objects are
+// encrypted.
+type Options struct {
+	// Storage backend, either "s3" (the default) or "fs".
+	Backend string
+
+	// Connection details of the S3-compliant bucket, used by the s3 backend.
+	Host   string
+	Key    string
+	Secret string
+	Bucket string
+
+	// Region of the bucket. Detected automatically if empty.
+	Region string
+
+	// Where the s3 backend's credentials are resolved from: "static" (Key and
+	// Secret, the default), "env" (AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY
+	// and AWS_SESSION_TOKEN), "file" (an AWS shared credentials file,
+	// including credential_process), "iam" (the EC2 or ECS metadata endpoint)
+	// or "chain" (env, file and iam, in that order).
+	Credentials string
+
+	// Shared credentials file and profile used by the file credentials. Default
+	// to ~/.aws/credentials and AWS_PROFILE (or "default") if empty.
+	CredentialsFile    string
+	CredentialsProfile string
+
+	// Metadata endpoint used by the iam credentials. Detected automatically if
+	// empty.
+	IAMEndpoint string
+
+	// How buckets are addressed: "dns" (virtual-host style), "path" or "auto"
+	// (the default, detected from the host).
+	BucketLookup string
+
+	// PEM file of additional certificate authorities to trust, i.e. for an
+	// on-prem MinIO with a private CA.
+	CABundle string
+
+	// Whether TLS certificates are not verified at all. Only meant for
+	// testing.
+	InsecureSkipVerify bool
+
+	// URL of the HTTP proxy requests are sent through. Defaults to the
+	// HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables if empty.
+	Proxy string
+
+	// How long connecting (including the TLS handshake) may take, and how long
+	// to wait for the server to respond to each request. Defaults are used if
+	// zero.
+	ConnectTimeout time.Duration
+	RequestTimeout time.Duration
+
+	// Directory used by the fs backend.
+	Path string
+
+	// Store used instead of the backend described above, if set.
+	Store Store
+
+	// Whether uploads are encrypted, with the key file or passphrase below.
+	// Environments with recipients are always encrypted to them.
+	Encrypt bool
+
+	// Key file used to encrypt and decrypt objects. Setting it enables
+	// encryption.
+	KeyFile string
+
+	// Private key used to decrypt objects encrypted to a set of recipients.
+	IdentityFile string
+
+	// Directory holding this machine's trusted copy of the recipients of each
+	// environment. If set, uploads are refused while an environment lists
+	// recipients missing from its trusted copy (see TrustRecipients), as the
+	// list itself is stored unauthenticated in the bucket.
+	TrustedRecipientsDir string
+
+	// Returns the passphrase used when encrypting without a key file.
+	Passphrase func(confirm bool) (string, error)
+}
+
+// Client manages the environments and files held by a Store.
+type Client struct {
+	store Store
+	opts  Options
+}
+
+// Returns a Client for the storage described by opts.
+func New(opts Options) (*Client, error) {
+	store := opts.Store
+
+	if store == nil {
+		switch opts.Backend {
+		case "", "s3":
+			client, err := NewS3Client(opts)
+			if err != nil {
+				return nil, fmt.Errorf("error creating new client: %w", err)
+			}
+
+			store = NewS3Store(client, opts.Bucket)
+		case "fs":
+			var err error
+			if store, err = NewFSStore(opts.Path); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unknown backend %q", opts.Backend)
+		}
+	}
+
+	return &Client{store: store, opts: opts}, nil
+}
+
+// Returns the Store the client talks to.
+func (c *Client) Store() Store {
+	return c.store
+}
+
+// Resolves the encryption settings for a given environment. Environments with
+// recipients are always encrypted to those recipients.
+func (c *Client) Encryption(ctx context.Context, env string) (Encryption, error) {
+	recipients, err := c.Recipients(ctx, env)
+	if err != nil {
+		return Encryption{}, err
+	}
+
+	return Encryption{
+		Enabled:      c.opts.Encrypt || c.opts.KeyFile != "" || len(recipients) > 0,
+		KeyFile:      c.opts.KeyFile,
+		Recipients:   recipients,
+		IdentityFile: c.opts.IdentityFile,
+		Passphrase:   c.opts.Passphrase,
+	}, nil
+}
+
+// Reads an object as-is, without decrypting it.
+func (c *Client) getObject(ctx context.Context, key string) ([]byte, error) {
+	object, err := c.store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+
+	return io.ReadAll(object)
+}
+
+// Uploads an object as-is, without encrypting it.
+func (c *Client) putObject(ctx context.Context, key string, data []byte, contentType string) error {
+	return c.store.Put(ctx, key, bytes.NewReader(data), int64(len(data)), contentType)
+}
+
+// Fetches an object belonging to an environment, decrypting it if needed.
+func (c *Client) fetch(ctx context.Context, env string, key string) ([]byte, error) {
+	enc, err := c.Encryption(ctx, env)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := c.getObject(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return enc.Open(data)
+}
+
+// Uploads an object belonging to an environment, encrypting it if enabled.
+func (c *Client) upload(ctx context.Context, env string, key string, data []byte) error {
+	enc, err := c.Encryption(ctx, env)
+	if err != nil {
+		return err
+	}
+
+	if err = c.checkRecipients(env, enc.Recipients); err != nil {
+		return err
+	}
+
+	if enc.Enabled {
+		if data, err = enc.Seal(data); err != nil {
+			return err
+		}
+	}
+
+	return c.putObject(ctx, key, data, contentType(data))
+}
+
+// Returns the content type objects holding data are stored with: encrypted
+// objects are binary, others plain text.
+func contentType(data []byte) string {
+	if IsEncrypted(data) {
+		return "application/octet-stream"
+	}
+
+	return "text/plain"
+}
+
+// Returns nil if key does not exist, or an error wrapping ErrConflict if it
+// does.
+func (c *Client) ensureAbsent(ctx context.Context, key string) error {
+	if _, err := c.store.Stat(ctx, key); err == nil {
+		return fmt.Errorf("%s: %w", key, ErrConflict)
+	} else if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,357 @@
+package copycat
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+
+	client, err := New(Options{Store: newMemStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.PutEnvironment(ctx, "staging", []byte("KEY=value\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.PutFile(ctx, "staging", "secrets.txt", []byte("aws secrets")); err != nil {
+		t.Fatal(err)
+	}
+
+	envs, err := client.ListEnvironments(ctx)
+	if err != nil || len(envs) != 1 || envs[0].Name != "staging" {
+		t.Errorf("Unexpected environments: %+v (%v)", envs, err)
+	}
+
+	vars, err := client.ParseEnvironment(ctx, "staging")
+	if err != nil || vars["KEY"] != "value" {
+		t.Errorf("Unexpected environment: %v (%v)", vars, err)
+	}
+
+	files, err := client.ListFiles(ctx, "staging")
+	if err != nil || len(files) != 1 || files[0].Name != "secrets.txt" {
+		t.Errorf("Unexpected files: %+v (%v)", files, err)
+	}
+
+	if data, err := client.GetFile(ctx, "staging", "secrets.txt"); err != nil || string(data) != "aws secrets" {
+		t.Errorf("Unexpected file: %q (%v)", data, err)
+	}
+
+	if _, err := client.GetEnvironment(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if _, err := client.GetFile(ctx, "staging", "missing.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	if err := client.MoveFile(ctx, "staging", "secrets.txt", "moved.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.StatFile(ctx, "staging", "secrets.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("File was not moved")
+	}
+
+	if err := client.CopyEnvironment(ctx, "staging", "production"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.CopyEnvironment(ctx, "staging", "production"); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected ErrConflict, got %v", err)
+	}
+	if data, err := client.GetFile(ctx, "production", "moved.txt"); err != nil || string(data) != "aws secrets" {
+		t.Errorf("Files were not copied: %q (%v)", data, err)
+	}
+
+	if err := client.RenameEnvironment(ctx, "production", "prod"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.DeleteEnvironment(ctx, "prod"); err != nil {
+		t.Fatal(err)
+	}
+
+	if envs, _ := client.ListEnvironments(ctx); len(envs) != 1 {
+		t.Errorf("Unexpected environments after rename and delete: %+v", envs)
+	}
+	if keys, _ := client.store.List(ctx, "prod"); len(keys) != 0 {
+		t.Errorf("Objects left behind: %+v", keys)
+	}
+}
+
+func TestClientVersionedEnvironments(t *testing.T) {
+	ctx := context.Background()
+	store := newVersionedMemStore()
+
+	client, err := New(Options{Store: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client.PutEnvironment(ctx, "staging", []byte("VERSION=1\n"))
+	client.PutEnvironment(ctx, "staging", []byte("VERSION=2\n"))
+	client.PutFile(ctx, "staging", "old.txt", []byte("old"))
+	client.DeleteFile(ctx, "staging", "old.txt")
+	client.PutFile(ctx, "staging", "secrets.txt", []byte("aws secrets"))
+
+	if err := client.RenameEnvironment(ctx, "staging", "prod"); err != nil {
+		t.Fatal(err)
+	}
+
+	versions, err := client.Versions(ctx, "prod")
+	if err != nil || len(versions) != 2 {
+		t.Fatalf("Expected the history to be renamed, got %+v (%v)", versions, err)
+	}
+	if data, _ := client.GetEnvironment(ctx, "prod"); string(data) != "VERSION=2\n" {
+		t.Errorf("Unexpected latest version after rename: %q", data)
+	}
+	if data, _ := client.GetEnvironmentVersion(ctx, "prod", versions[1].VersionID); string(data) != "VERSION=1\n" {
+		t.Errorf("Unexpected prior version after rename: %q", data)
+	}
+
+	for key := range store.versions {
+		if strings.HasPrefix(key, "staging") || key == "env_staging" {
+			t.Errorf("Versions left behind under the old name: %s", key)
+		}
+	}
+
+	if err := client.DeleteEnvironment(ctx, "prod"); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.versions) != 0 {
+		t.Errorf("Versions left behind after delete: %d", len(store.versions))
+	}
+}
+
+// A Store whose listings only report whole seconds, like S3.
+type secondsStore struct {
+	Store
+}
+
+func (s secondsStore) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	objects, err := s.Store.List(ctx, prefix)
+	for i := range objects {
+		objects[i].LastModified = objects[i].LastModified.Truncate(time.Second)
+	}
+
+	return objects, err
+}
+
+func TestClientHistory(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := NewFSStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := New(Options{Store: secondsStore{store}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Uploaded within the same second.
+	for _, env := range []string{"VERSION=1\n", "VERSION=2\n", "VERSION=3\n"} {
+		if err := client.PutEnvironment(ctx, "staging", []byte(env)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	versions, err := client.Versions(ctx, "staging")
+	if err != nil || len(versions) != 3 || !versions[0].IsLatest {
+		t.Fatalf("Unexpected versions: %+v (%v)", versions, err)
+	}
+
+	contents := map[string]string{}
+	for _, version := range versions {
+		data, err := client.GetEnvironmentVersion(ctx, "staging", version.VersionID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents[string(data)] = version.VersionID
+	}
+	if len(contents) != 3 || contents["VERSION=3\n"] != versions[0].VersionID {
+		t.Fatalf("Unexpected version contents: %v", contents)
+	}
+
+	if err := client.Rollback(ctx, "staging", contents["VERSION=1\n"]); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := client.GetEnvironment(ctx, "staging"); string(data) != "VERSION=1\n" {
+		t.Errorf("Rolled back environment does not match: %q", data)
+	}
+	if data, err := client.GetEnvironmentVersion(ctx, "staging", contents["VERSION=3\n"]); err != nil || string(data) != "VERSION=3\n" {
+		t.Errorf("Expected the replaced version to be kept: %q (%v)", data, err)
+	}
+}
+
+func TestCopyKeepsUploadTime(t *testing.T) {
+	ctx := context.Background()
+
+	client, err := New(Options{Backend: "fs", Path: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client.PutEnvironment(ctx, "staging", []byte("VERSION=1\n"))
+	original, err := client.Versions(ctx, "staging")
+	if err != nil || len(original) != 1 {
+		t.Fatalf("Unexpected versions: %+v (%v)", original, err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if err := client.RenameEnvironment(ctx, "staging", "prod"); err != nil {
+		t.Fatal(err)
+	}
+
+	versions, err := client.Versions(ctx, "prod")
+	if err != nil || len(versions) != 1 {
+		t.Fatalf("Unexpected versions: %+v (%v)", versions, err)
+	}
+	if versions[0].VersionID != original[0].VersionID || !versions[0].LastModified.Equal(original[0].LastModified.Truncate(time.Millisecond)) {
+		t.Errorf("Expected the upload time to be kept, got %+v instead of %+v", versions[0], original[0])
+	}
+
+	// Uploading the same contents again is a new version.
+	client.PutEnvironment(ctx, "prod", []byte("VERSION=1\n"))
+	if versions, _ = client.Versions(ctx, "prod"); len(versions) != 2 || versions[0].VersionID == original[0].VersionID {
+		t.Errorf("Unexpected versions after uploading again: %+v", versions)
+	}
+}
+
+func TestRollbackRecipients(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	alice, alicePublic := newTestIdentity(t, dir, "alice")
+	bob, bobPublic := newTestIdentity(t, dir, "bob")
+	aliceKey, _ := ParsePublicKey(alicePublic)
+	bobKey, _ := ParsePublicKey(bobPublic)
+
+	client, err := New(Options{Backend: "fs", Path: t.TempDir(), IdentityFile: alice})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client.PutEnvironment(ctx, "staging", []byte("VERSION=1\n"))
+	if err := client.SetRecipients(ctx, "staging", [][]byte{aliceKey, bobKey}); err != nil {
+		t.Fatal(err)
+	}
+	client.PutEnvironment(ctx, "staging", []byte("VERSION=2\n"))
+
+	// Bob is offboarded, then the version he could read is restored.
+	if err := client.SetRecipients(ctx, "staging", [][]byte{aliceKey}); err != nil {
+		t.Fatal(err)
+	}
+
+	versions, err := client.Versions(ctx, "staging")
+	if err != nil || len(versions) < 2 {
+		t.Fatalf("Unexpected versions: %+v (%v)", versions, err)
+	}
+	if err := client.Rollback(ctx, "staging", versions[len(versions)-1].VersionID); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := client.getObject(ctx, "env_staging")
+	if _, err := (Encryption{IdentityFile: bob}).Open(data); err == nil {
+		t.Errorf("Rolled back version can still be decrypted by a removed recipient")
+	}
+	if data, err := client.GetEnvironment(ctx, "staging"); err != nil || string(data) != "VERSION=1\n" {
+		t.Errorf("Rolled back environment does not match: %q (%v)", data, err)
+	}
+}
+
+func TestClientRecipients(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	alice, alicePublic := newTestIdentity(t, dir, "alice")
+
+	public, err := ParsePublicKey(alicePublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := newMemStore()
+	client, err := New(Options{Store: store, IdentityFile: alice, TrustedRecipientsDir: filepath.Join(dir, "trusted")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client.PutEnvironment(ctx, "staging", []byte("KEY=value\n"))
+	client.PutFile(ctx, "staging", "secrets.txt", []byte("aws secrets"))
+
+	if err := client.SetRecipients(ctx, "staging", [][]byte{public}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"env_staging", "staging_uploads/secrets.txt"} {
+		if data, _ := client.getObject(ctx, key); !IsEncrypted(data) {
+			t.Errorf("%s was not re-encrypted", key)
+		}
+	}
+
+	if data, err := client.GetEnvironment(ctx, "staging"); err != nil || string(data) != "KEY=value\n" {
+		t.Errorf("Recipient could not decrypt: %q (%v)", data, err)
+	}
+
+	if err := client.SetRecipients(ctx, "missing", [][]byte{public}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	// The recipient list is only written once every object was re-encrypted.
+	_, bobPublic := newTestIdentity(t, dir, "bob")
+	bob, _ := ParsePublicKey(bobPublic)
+	store.objects["staging_uploads/secrets.txt"][len(store.objects["staging_uploads/secrets.txt"])-1] ^= 1
+	if err := client.SetRecipients(ctx, "staging", [][]byte{public, bob}); err == nil {
+		t.Fatalf("Expected re-encrypting a tampered file to fail")
+	}
+	if recipients, _ := client.Recipients(ctx, "staging"); len(recipients) != 1 {
+		t.Errorf("Recipients were updated although re-encryption failed: %d", len(recipients))
+	}
+	client.PutFile(ctx, "staging", "secrets.txt", []byte("aws secrets"))
+
+	// A key added to the list in the bucket, but not from this client, is not
+	// trusted until reviewed.
+	_, evePublic := newTestIdentity(t, dir, "eve")
+	store.objects["staging_recipients"] = append(store.objects["staging_recipients"], []byte(evePublic+"\n")...)
+
+	if untrusted, err := client.UntrustedRecipients(ctx, "staging"); err != nil || len(untrusted) != 1 {
+		t.Errorf("Expected one untrusted recipient, got %d (%v)", len(untrusted), err)
+	}
+	if err := client.PutEnvironment(ctx, "staging", []byte("KEY=other\n")); !errors.Is(err, ErrUntrustedRecipients) {
+		t.Errorf("Expected uploads to be refused, got %v", err)
+	}
+
+	if err := client.TrustRecipients(ctx, "staging"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.PutEnvironment(ctx, "staging", []byte("KEY=other\n")); err != nil {
+		t.Errorf("Expected trusted recipients to be accepted, got %v", err)
+	}
+}
+
+func TestNewFromProfile(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("COPYCAT_CONFIG_DIR", "")
+
+	if _, err := NewFromProfile("default"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	path := filepath.Join(home, ".config", "copycat")
+	os.MkdirAll(path, 0700)
+	os.WriteFile(filepath.Join(path, "default"), []byte("BACKEND=fs\nPATH="+t.TempDir()+"\n"), 0600)
+
+	client, err := NewFromProfile("default")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.PutEnvironment(context.Background(), "staging", []byte("KEY=value\n")); err != nil {
+		t.Errorf("Error uploading through profile: %s", err)
+	}
+}
@@ -0,0 +1,283 @@
+package copycat
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Name of the file (in ConfigDir) holding every profile.
+const ConfigFile = "config.yml"
+
+// Contents of the config file.
+type Config struct {
+	// Profile used when none is given, "default" if empty.
+	Default string `yaml:"default,omitempty"`
+
+	Profiles map[string]Profile `yaml:"profiles"`
+}
+
+// Settings of a single profile. See Options for the meaning of each.
+type Profile struct {
+	Backend string `yaml:"backend,omitempty"`
+	Host    string `yaml:"host,omitempty"`
+	Key     string `yaml:"key,omitempty"`
+	Secret  string `yaml:"secret,omitempty"`
+	Bucket  string `yaml:"bucket,omitempty"`
+	Region  string `yaml:"region,omitempty"`
+	Path    string `yaml:"path,omitempty"`
+
+	// Where the secret is kept: in the config file (if empty), or in the OS
+	// keyring ("keyring").
+	SecretStore string `yaml:"secret_store,omitempty"`
+
+	Credentials        string `yaml:"credentials,omitempty"`
+	CredentialsFile    string `yaml:"credentials_file,omitempty"`
+	CredentialsProfile string `yaml:"credentials_profile,omitempty"`
+	IAMEndpoint        string `yaml:"iam_endpoint,omitempty"`
+
+	BucketLookup       string        `yaml:"bucket_lookup,omitempty"`
+	CABundle           string        `yaml:"ca_bundle,omitempty"`
+	InsecureSkipVerify bool          `yaml:"insecure_skip_verify,omitempty"`
+	Proxy              string        `yaml:"proxy,omitempty"`
+	ConnectTimeout     time.Duration `yaml:"connect_timeout,omitempty"`
+	RequestTimeout     time.Duration `yaml:"request_timeout,omitempty"`
+
+	Encryption ProfileEncryption `yaml:"encryption,omitempty"`
+
+	// Environment used by commands when none is given.
+	Environment string `yaml:"environment,omitempty"`
+
+	// Whether to skip the daily check for a newer release of the CLI.
+	NoUpdateCheck bool `yaml:"no_update_check,omitempty"`
+}
+
+// Encryption settings of a profile.
+type ProfileEncryption struct {
+	// Whether uploads are encrypted with a passphrase.
+	Passphrase bool `yaml:"passphrase,omitempty"`
+
+	KeyFile      string `yaml:"key_file,omitempty"`
+	IdentityFile string `yaml:"identity_file,omitempty"`
+}
+
+// Returns the path to the config file.
+func ConfigPath() (string, error) {
+	dir, err := ConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, ConfigFile), nil
+}
+
+// Reads the config file, migrating the profiles of older versions (one
+// KEY=value file per profile) into it first if it does not exist yet. Returns
+// an error wrapping ErrInsecureProfile if it holds a secret and can be read by
+// other users.
+func LoadConfig() (*Config, error) {
+	config, err := readConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, profile := range config.Profiles {
+		if profile.Secret == "" {
+			continue
+		}
+
+		path, err := ConfigPath()
+		if err != nil {
+			return nil, err
+		}
+		if info, err := os.Stat(path); err == nil && InsecurePermissions(info.Mode()) {
+			return nil, fmt.Errorf("%s (mode %04o): %w", path, info.Mode().Perm(), ErrInsecureProfile)
+		}
+		break
+	}
+
+	return config, nil
+}
+
+// Reads the config file (see LoadConfig), regardless of its permissions.
+func readConfig() (*Config, error) {
+	path, err := ConfigPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return migrateConfig(filepath.Dir(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+
+	config := &Config{}
+
+	// Unknown keys are most likely typos, which would otherwise be silently
+	// ignored.
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err = decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("error reading config file %s: %w", path, err)
+	}
+
+	if config.Profiles == nil {
+		config.Profiles = map[string]Profile{}
+	}
+
+	return config, nil
+}
+
+// Writes the config file, readable by the current user only. Secrets kept in
+// the OS keyring are left out.
+func (c *Config) Save() error {
+	path, err := ConfigPath()
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	stored := Config{Default: c.Default, Profiles: map[string]Profile{}}
+	for name, profile := range c.Profiles {
+		if profile.SecretStore == "keyring" {
+			profile.Secret = ""
+		}
+		stored.Profiles[name] = profile
+	}
+
+	data, err := yaml.Marshal(stored)
+	if err != nil {
+		return fmt.Errorf("error encoding config file: %w", err)
+	}
+
+	// WriteFile keeps the mode of existing files, so tighten it explicitly.
+	if err = os.WriteFile(path, data, 0600); err == nil {
+		err = os.Chmod(path, 0600)
+	}
+	if err != nil {
+		return fmt.Errorf("error writing config file: %w", err)
+	}
+
+	return nil
+}
+
+// Name of the file (in ConfigDir) older versions kept the default profile in.
+const legacyDefaultFile = ".default"
+
+// Builds the config from the profiles of older versions, each a KEY=value file
+// in dir named after the profile. If there are any, the config file is written
+// and the old files are removed. Returns an empty config otherwise.
+func migrateConfig(dir string) (*Config, error) {
+	config := &Config{Profiles: map[string]Profile{}}
+
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var migrated []string
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || checkProfileName(entry.Name()) != nil || entry.Name() == ConfigFile {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		settings, err := godotenv.Read(path)
+		if err != nil {
+			return nil, fmt.Errorf("error migrating profile %s: %w", entry.Name(), err)
+		}
+
+		if config.Profiles[entry.Name()], err = profileFromSettings(settings); err != nil {
+			return nil, fmt.Errorf("error migrating profile %s: %w", entry.Name(), err)
+		}
+		migrated = append(migrated, path)
+	}
+
+	if len(migrated) == 0 {
+		return config, nil
+	}
+	sort.Strings(migrated)
+
+	if data, err := os.ReadFile(filepath.Join(dir, legacyDefaultFile)); err == nil {
+		config.Default = string(bytes.TrimSpace(data))
+		migrated = append(migrated, filepath.Join(dir, legacyDefaultFile))
+	}
+
+	if err = config.Save(); err != nil {
+		return nil, err
+	}
+
+	// The old files are only removed once the config file holds them all.
+	for _, path := range migrated {
+		if err = os.Remove(path); err != nil {
+			return nil, fmt.Errorf("error removing migrated profile: %w", err)
+		}
+	}
+
+	return config, nil
+}
+
+// Returns the profile described by the settings of a KEY=value profile file,
+// as written by older versions.
+func profileFromSettings(settings map[string]string) (Profile, error) {
+	profile := Profile{
+		Backend:     settings["BACKEND"],
+		Host:        settings["HOSTNAME"],
+		Key:         settings["KEY"],
+		Secret:      settings["SECRET"],
+		Bucket:      settings["BUCKET"],
+		Region:      settings["REGION"],
+		Path:        settings["PATH"],
+		SecretStore: settings["SECRET_STORE"],
+
+		Credentials:        settings["CREDENTIALS"],
+		CredentialsFile:    settings["CREDENTIALS_FILE"],
+		CredentialsProfile: settings["CREDENTIALS_PROFILE"],
+		IAMEndpoint:        settings["IAM_ENDPOINT"],
+
+		BucketLookup: settings["BUCKET_LOOKUP"],
+		CABundle:     settings["CA_BUNDLE"],
+		Proxy:        settings["PROXY"],
+
+		Encryption: ProfileEncryption{
+			KeyFile:      settings["ENCRYPTION_KEY_FILE"],
+			IdentityFile: settings["IDENTITY_FILE"],
+		},
+	}
+
+	if value := settings["INSECURE_SKIP_VERIFY"]; value != "" {
+		var err error
+		if profile.InsecureSkipVerify, err = strconv.ParseBool(value); err != nil {
+			return Profile{}, fmt.Errorf("invalid INSECURE_SKIP_VERIFY %q, expected true or false", value)
+		}
+	}
+
+	for key, timeout := range map[string]*time.Duration{"CONNECT_TIMEOUT": &profile.ConnectTimeout, "REQUEST_TIMEOUT": &profile.RequestTimeout} {
+		if value := settings[key]; value != "" {
+			var err error
+			if *timeout, err = time.ParseDuration(value); err != nil {
+				return Profile{}, fmt.Errorf("invalid %s %q, expected a duration such as 30s", key, value)
+			}
+		}
+	}
+
+	return profile, nil
+}
@@ -0,0 +1,316 @@
+package copycat
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Every encrypted object starts with the following header:
+//
+//	magic (7 bytes) | version (1 byte) | mode (1 byte) | salt (16 bytes) | nonce (24 bytes)
+//
+// objects encrypted to a set of recipients then carry a one byte recipient
+// count, followed by one stanza per recipient:
+//
+//	ephemeral public key (32 bytes) | wrapped content key (48 bytes)
+//
+// The rest of the object is the XChaCha20-Poly1305 sealed contents. The whole
+// header (including any stanzas) is authenticated as additional data.
+const (
+	encryptionMagic   = "COPYCAT"
+	encryptionVersion = 1
+
+	saltSize   = 16
+	headerSize = len(encryptionMagic) + 2 + saltSize + chacha20poly1305.NonceSizeX
+	stanzaSize = curve25519.PointSize + chacha20poly1305.KeySize + chacha20poly1305.Overhead
+)
+
+// Describes how the content key is derived from the user's secret.
+const (
+	modeKeyFile    byte = 1
+	modePassphrase byte = 2
+	modeRecipients byte = 3
+)
+
+// Encryption describes how objects are encrypted before being uploaded, and
+// how encrypted objects are decrypted.
+type Encryption struct {
+	// Whether uploads should be encrypted.
+	Enabled bool
+
+	// Key file used to encrypt and decrypt objects. If empty, a passphrase is
+	// used instead.
+	KeyFile string
+
+	// X25519 public keys uploads are encrypted to. Takes precedence over the
+	// key file and passphrase.
+	Recipients [][]byte
+
+	// Private key used to decrypt objects encrypted to a set of recipients.
+	IdentityFile string
+
+	// Returns the passphrase used when no key file is set. confirm is set when
+	// encrypting, so interactive implementations can ask twice.
+	Passphrase func(confirm bool) (string, error)
+}
+
+// Returned (wrapped) by Open when encryption is enabled, but the object is
+// plaintext, i.e. because it was replaced by someone with access to the bucket.
+var ErrNotEncrypted = errors.New("object is not encrypted")
+
+// Returns whether the given data starts with an encryption header: the magic,
+// followed by a supported version and a known mode. Plaintext which merely
+// starts with the magic (i.e. a COPYCAT_TOKEN=... line) is not mistaken for
+// one.
+func IsEncrypted(data []byte) bool {
+	if len(data) < len(encryptionMagic)+2 || !bytes.HasPrefix(data, []byte(encryptionMagic)) {
+		return false
+	}
+
+	switch data[len(encryptionMagic)+1] {
+	case modeKeyFile, modePassphrase, modeRecipients:
+		return data[len(encryptionMagic)] == encryptionVersion
+	}
+
+	return false
+}
+
+// Encrypts data, returning it untouched if encryption is disabled.
+func (e Encryption) Seal(data []byte) ([]byte, error) {
+	if !e.Enabled {
+		return data, nil
+	}
+
+	mode := modePassphrase
+	if len(e.Recipients) > 0 {
+		mode = modeRecipients
+	} else if e.KeyFile != "" {
+		mode = modeKeyFile
+	}
+
+	header := make([]byte, headerSize)
+	copy(header, encryptionMagic)
+	header[len(encryptionMagic)] = encryptionVersion
+	header[len(encryptionMagic)+1] = mode
+
+	if _, err := rand.Read(header[len(encryptionMagic)+2:]); err != nil {
+		return nil, fmt.Errorf("error generating nonce: %w", err)
+	}
+
+	salt := header[len(encryptionMagic)+2 : len(encryptionMagic)+2+saltSize]
+	nonce := header[len(encryptionMagic)+2+saltSize:]
+
+	var key []byte
+	var err error
+
+	if mode == modeRecipients {
+		if len(e.Recipients) > 255 {
+			return nil, errors.New("too many recipients")
+		}
+
+		// A random content key, wrapped for every recipient.
+		key = make([]byte, chacha20poly1305.KeySize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("error generating key: %w", err)
+		}
+
+		header = append(header, byte(len(e.Recipients)))
+		for _, recipient := range e.Recipients {
+			stanza, err := wrapKey(key, recipient)
+			if err != nil {
+				return nil, err
+			}
+			header = append(header, stanza...)
+		}
+	} else if key, err = e.key(mode, salt, true); err != nil {
+		return nil, err
+	}
+
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// The header is authenticated, and prefixed to the sealed contents.
+	out := make([]byte, len(header), len(header)+len(data)+aead.Overhead())
+	copy(out, header)
+
+	return aead.Seal(out, nonce, data, header), nil
+}
+
+// Decrypts data, returning it untouched if it was never encrypted. Unencrypted
+// data is refused with an error wrapping ErrNotEncrypted if encryption is
+// enabled, so it cannot be substituted for an encrypted object.
+func (e Encryption) Open(data []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		if e.Enabled {
+			return nil, fmt.Errorf("%w, although encryption is enabled (it may have been replaced by someone with access to the bucket)", ErrNotEncrypted)
+		}
+		return data, nil
+	}
+
+	if len(data) < headerSize {
+		return nil, errors.New("encrypted object is truncated")
+	}
+
+	header := data[:headerSize]
+	mode := header[len(encryptionMagic)+1]
+	salt := header[len(encryptionMagic)+2 : len(encryptionMagic)+2+saltSize]
+	nonce := header[len(encryptionMagic)+2+saltSize:]
+
+	var key []byte
+	var err error
+
+	if mode == modeRecipients {
+		if len(data) < headerSize+1 {
+			return nil, errors.New("encrypted object is truncated")
+		}
+
+		end := headerSize + 1 + int(data[headerSize])*stanzaSize
+		if len(data) < end {
+			return nil, errors.New("encrypted object is truncated")
+		}
+
+		header = data[:end]
+		if key, err = e.unwrapKey(data[headerSize+1 : end]); err != nil {
+			return nil, err
+		}
+	} else if key, err = e.key(mode, salt, false); err != nil {
+		return nil, err
+	}
+
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := aead.Open(nil, nonce, data[len(header):], header)
+	if err != nil {
+		return nil, errors.New("decryption failed: wrong key or passphrase, or the object was tampered with")
+	}
+
+	return plaintext, nil
+}
+
+// Wraps a content key for a single recipient, using an ephemeral X25519 key
+// pair. Returns the resulting stanza.
+func wrapKey(key []byte, recipient []byte) ([]byte, error) {
+	ephemeral := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(ephemeral); err != nil {
+		return nil, fmt.Errorf("error generating key: %w", err)
+	}
+
+	ephemeralPublic, err := curve25519.X25519(ephemeral, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+
+	shared, err := curve25519.X25519(ephemeral, recipient)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient: %w", err)
+	}
+
+	aead, err := stanzaCipher(shared, ephemeralPublic, recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every stanza uses a fresh ephemeral key, so a fixed nonce is safe.
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+
+	return aead.Seal(ephemeralPublic, nonce, key, nil), nil
+}
+
+// Finds the stanza wrapped for our identity, and returns the content key.
+func (e Encryption) unwrapKey(stanzas []byte) ([]byte, error) {
+	identity, err := ReadIdentity(e.IdentityFile)
+	if err != nil {
+		return nil, err
+	}
+
+	public, err := curve25519.X25519(identity, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+
+	for i := 0; i < len(stanzas); i += stanzaSize {
+		ephemeralPublic := stanzas[i : i+curve25519.PointSize]
+
+		shared, err := curve25519.X25519(identity, ephemeralPublic)
+		if err != nil {
+			continue
+		}
+
+		aead, err := stanzaCipher(shared, ephemeralPublic, public)
+		if err != nil {
+			return nil, err
+		}
+
+		if key, err := aead.Open(nil, nonce, stanzas[i+curve25519.PointSize:i+stanzaSize], nil); err == nil {
+			return key, nil
+		}
+	}
+
+	return nil, errors.New("decryption failed: this identity is not a recipient of the object")
+}
+
+// Returns the cipher used to (un)wrap a stanza's content key, derived from the
+// X25519 shared secret and both public keys involved.
+func stanzaCipher(shared []byte, ephemeralPublic []byte, recipient []byte) (cipher.AEAD, error) {
+	key := make([]byte, chacha20poly1305.KeySize)
+	salt := append(append([]byte{}, ephemeralPublic...), recipient...)
+
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte("copycat recipient")), key); err != nil {
+		return nil, err
+	}
+
+	return chacha20poly1305.New(key)
+}
+
+// Derives the content key for the given mode and salt.
+func (e Encryption) key(mode byte, salt []byte, encrypting bool) ([]byte, error) {
+	switch mode {
+	case modeKeyFile:
+		if e.KeyFile == "" {
+			return nil, errors.New("object is encrypted with a key file, but none was given")
+		}
+
+		secret, err := os.ReadFile(e.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading key file: %w", err)
+		}
+
+		key := make([]byte, chacha20poly1305.KeySize)
+		if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte("copycat key-file")), key); err != nil {
+			return nil, err
+		}
+
+		return key, nil
+	case modePassphrase:
+		if e.Passphrase == nil {
+			return nil, errors.New("object is encrypted with a passphrase, but none was given")
+		}
+
+		passphrase, err := e.Passphrase(encrypting)
+		if err != nil {
+			return nil, err
+		}
+
+		return scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, chacha20poly1305.KeySize)
+	default:
+		return nil, fmt.Errorf("unsupported encryption mode %d", mode)
+	}
+}
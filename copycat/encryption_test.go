@@ -0,0 +1,119 @@
+package copycat
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncryption(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	otherKeyFile := filepath.Join(dir, "other")
+	os.WriteFile(keyFile, []byte("correct horse battery staple"), 0600)
+	os.WriteFile(otherKeyFile, []byte("incorrect horse battery staple"), 0600)
+
+	data := []byte("KEY=value\n")
+	cases := map[string]Encryption{
+		"key file":   {Enabled: true, KeyFile: keyFile},
+		"passphrase": {Enabled: true, Passphrase: func(bool) (string, error) { return "hunter2", nil }},
+	}
+
+	for name, enc := range cases {
+		t.Run(name, func(t *testing.T) {
+			sealed, err := enc.Seal(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !IsEncrypted(sealed) || bytes.Contains(sealed, data) {
+				t.Fatalf("Data was not encrypted")
+			}
+
+			opened, err := enc.Open(sealed)
+			if err != nil || !bytes.Equal(opened, data) {
+				t.Fatalf("Decrypted data does not match: %q (%v)", opened, err)
+			}
+
+			// Flipping any bit must be detected.
+			sealed[len(sealed)-1] ^= 1
+			if _, err := enc.Open(sealed); err == nil {
+				t.Errorf("Tampered data was decrypted")
+			}
+		})
+	}
+
+	sealed, _ := cases["key file"].Seal(data)
+	if _, err := (Encryption{KeyFile: otherKeyFile}).Open(sealed); err == nil {
+		t.Errorf("Data was decrypted with the wrong key file")
+	}
+	if _, err := (Encryption{}).Open(sealed); err == nil {
+		t.Errorf("Data was decrypted without a key file")
+	}
+
+	// Unencrypted data is passed through untouched, even if it starts with the
+	// magic, unless encryption is enabled.
+	for _, plaintext := range [][]byte{data, []byte("COPYCAT_TOKEN=abc\n"), []byte("COPYCAT")} {
+		if opened, err := (Encryption{}).Open(plaintext); err != nil || !bytes.Equal(opened, plaintext) {
+			t.Errorf("Plaintext was not passed through: %q (%v)", opened, err)
+		}
+		if _, err := cases["key file"].Open(plaintext); !errors.Is(err, ErrNotEncrypted) {
+			t.Errorf("Expected plaintext to be refused with encryption enabled, got %v", err)
+		}
+	}
+}
+
+// Creates a new identity in dir, returning its path and public key.
+func newTestIdentity(t *testing.T, dir string, name string) (string, string) {
+	path := filepath.Join(dir, name)
+	identity, err := GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteIdentity(path, identity); err != nil {
+		t.Fatal(err)
+	}
+
+	public, err := PublicKey(identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path, base64.StdEncoding.EncodeToString(public)
+}
+
+func TestRecipients(t *testing.T) {
+	dir := t.TempDir()
+	alice, alicePublic := newTestIdentity(t, dir, "alice")
+	bob, bobPublic := newTestIdentity(t, dir, "bob")
+	eve, _ := newTestIdentity(t, dir, "eve")
+
+	var recipients [][]byte
+	for _, key := range []string{alicePublic, bobPublic} {
+		public, err := ParsePublicKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		recipients = append(recipients, public)
+	}
+
+	data := []byte("KEY=value\n")
+	sealed, err := Encryption{Enabled: true, Recipients: recipients}.Seal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, identity := range []string{alice, bob} {
+		opened, err := Encryption{IdentityFile: identity}.Open(sealed)
+		if err != nil || !bytes.Equal(opened, data) {
+			t.Errorf("Recipient could not decrypt: %q (%v)", opened, err)
+		}
+	}
+
+	if _, err := (Encryption{IdentityFile: eve}).Open(sealed); err == nil {
+		t.Errorf("Data was decrypted by a non-recipient")
+	}
+}
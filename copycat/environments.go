@@ -0,0 +1,284 @@
+package copycat
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/joho/godotenv"
+)
+
+// Describes an environment.
+type Environment struct {
+	Name string `json:"name"`
+	ObjectInfo
+}
+
+// Returns every environment which has been created.
+func (c *Client) ListEnvironments(ctx context.Context) ([]Environment, error) {
+	objects, err := c.store.List(ctx, "env_")
+	if err != nil {
+		return nil, err
+	}
+
+	environments := []Environment{}
+	for _, object := range objects {
+		environments = append(environments, Environment{Name: strings.TrimPrefix(object.Key, "env_"), ObjectInfo: object})
+	}
+
+	return environments, nil
+}
+
+// Returns the metadata of an environment's .env file.
+func (c *Client) StatEnvironment(ctx context.Context, env string) (ObjectInfo, error) {
+	info, err := c.store.Stat(ctx, "env_"+env)
+	if errors.Is(err, ErrNotFound) {
+		return ObjectInfo{}, fmt.Errorf("environment %s: %w", env, ErrNotFound)
+	}
+
+	return info, err
+}
+
+// Returns the contents of an environment's .env file, decrypted if needed.
+func (c *Client) GetEnvironment(ctx context.Context, env string) ([]byte, error) {
+	data, err := c.fetch(ctx, env, "env_"+env)
+	if errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("environment %s: %w", env, ErrNotFound)
+	}
+
+	return data, err
+}
+
+// Fetches an environment, and parses it.
+func (c *Client) ParseEnvironment(ctx context.Context, env string) (map[string]string, error) {
+	data, err := c.GetEnvironment(ctx, env)
+	if err != nil {
+		return nil, err
+	}
+
+	return godotenv.Parse(bytes.NewReader(data))
+}
+
+// Uploads the contents of an environment's .env file, creating the environment
+// if needed. The version being replaced is kept in the environment's history.
+func (c *Client) PutEnvironment(ctx context.Context, env string, data []byte) error {
+	return c.replaceEnvironment(ctx, env, data)
+}
+
+// Returns every key belonging to an environment: the .env itself, its
+// recipients, the ID of its current version if it was copied, uploaded files
+// and archived versions.
+func (c *Client) EnvironmentKeys(ctx context.Context, env string) ([]string, error) {
+	if _, err := c.StatEnvironment(ctx, env); err != nil {
+		return nil, err
+	}
+
+	keys := []string{"env_" + env}
+
+	for _, key := range []string{env + "_recipients", versionKey(env)} {
+		if _, err := c.store.Stat(ctx, key); err == nil {
+			keys = append(keys, key)
+		} else if !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+	}
+
+	for _, prefix := range []string{filesPrefix(env), historyPrefix(env)} {
+		objects, err := c.store.List(ctx, prefix)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range objects {
+			keys = append(keys, object.Key)
+		}
+	}
+
+	return keys, nil
+}
+
+// Deletes an environment, alongside its files, recipients and history. On
+// buckets with versioning enabled, every version of its objects is removed
+// permanently, rather than hidden behind a delete marker.
+func (c *Client) DeleteEnvironment(ctx context.Context, env string) error {
+	keys, err := c.EnvironmentKeys(ctx, env)
+	if err != nil {
+		return err
+	}
+
+	return c.deleteEnvironment(ctx, env, keys)
+}
+
+// Copies an environment, alongside its files, recipients and history, to
+// another which must not exist yet. If any copy fails, the objects already
+// copied are removed again, so the destination is either complete or absent.
+func (c *Client) CopyEnvironment(ctx context.Context, from string, to string) error {
+	_, err := c.copyEnvironment(ctx, from, to)
+
+	return err
+}
+
+// Renames an environment, alongside its files, recipients and history. The
+// new name must not be taken yet. Nothing is left under the old name, including
+// prior versions kept by buckets with versioning enabled.
+func (c *Client) RenameEnvironment(ctx context.Context, from string, to string) error {
+	keys, err := c.copyEnvironment(ctx, from, to)
+	if err != nil {
+		return err
+	}
+
+	if err = c.deleteEnvironment(ctx, from, keys); err != nil {
+		return fmt.Errorf("environment copied to %s, but could not remove %s: %w", to, from, err)
+	}
+
+	return nil
+}
+
+// Copies every object of an environment to another, returning the keys which
+// were copied. On buckets with versioning enabled, every version of each object
+// is copied, oldest first, so the history carries over.
+func (c *Client) copyEnvironment(ctx context.Context, from string, to string) ([]string, error) {
+	if err := c.ensureAbsent(ctx, "env_"+to); err != nil {
+		if errors.Is(err, ErrConflict) {
+			return nil, fmt.Errorf("environment %s: %w", to, ErrConflict)
+		}
+		return nil, err
+	}
+
+	keys, err := c.EnvironmentKeys(ctx, from)
+	if err != nil {
+		return nil, err
+	}
+
+	versioned, enabled, err := c.versioning(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var copied []string
+	for _, key := range keys {
+		if enabled {
+			err = c.copyVersions(ctx, versioned, key, renameKey(key, from, to))
+		} else {
+			err = c.copyObject(ctx, key, renameKey(key, from, to))
+		}
+		if err != nil {
+			for _, key := range append(copied, renameKey(key, from, to)) {
+				if enabled {
+					versioned.DeleteVersions(ctx, key)
+				} else {
+					c.store.Delete(ctx, key)
+				}
+			}
+			return nil, fmt.Errorf("error copying %s: %w", key, err)
+		}
+		copied = append(copied, renameKey(key, from, to))
+	}
+
+	// Copies get new modification times, so the original ID of the current
+	// version is recorded, which keeps its upload time (see currentVersion).
+	if !enabled {
+		_, id, err := c.currentVersion(ctx, from)
+		if err == nil {
+			err = c.putObject(ctx, versionKey(to), []byte(id), "text/plain")
+		}
+		if err != nil {
+			for _, key := range append(copied, versionKey(to)) {
+				c.store.Delete(ctx, key)
+			}
+			return nil, fmt.Errorf("error recording the version of %s: %w", from, err)
+		}
+	}
+
+	return keys, nil
+}
+
+// Deletes the given keys of an environment. If the bucket keeps versions, every
+// version of the environment's objects is removed instead, including those of
+// files deleted earlier.
+func (c *Client) deleteEnvironment(ctx context.Context, env string, keys []string) error {
+	versioned, enabled, err := c.versioning(ctx)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return c.deleteKeys(ctx, keys)
+	}
+
+	// The .env comes last, so the environment remains visible should
+	// anything fail.
+	for _, key := range []string{historyPrefix(env), filesPrefix(env), env + "_recipients", versionKey(env), "env_" + env} {
+		if err := versioned.DeleteVersions(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Deletes the given keys in reverse, so an environment's .env (always listed
+// first) is removed last, and the environment remains visible should anything
+// fail.
+func (c *Client) deleteKeys(ctx context.Context, keys []string) error {
+	for i := len(keys) - 1; i >= 0; i-- {
+		if err := c.store.Delete(ctx, keys[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Given a key belonging to one environment, returns the equivalent key for
+// another environment.
+func renameKey(key string, from string, to string) string {
+	switch {
+	case key == "env_"+from:
+		return "env_" + to
+	case strings.HasPrefix(key, historyPrefix(from)):
+		return historyPrefix(to) + strings.TrimPrefix(key, historyPrefix(from))
+	default:
+		return to + strings.TrimPrefix(key, from)
+	}
+}
+
+// Copies a single object as-is, so encrypted objects stay encrypted.
+func (c *Client) copyObject(ctx context.Context, from string, to string) error {
+	data, err := c.getObject(ctx, from)
+	if err != nil {
+		return err
+	}
+
+	return c.putObject(ctx, to, data, contentType(data))
+}
+
+// Copies every version of an object as-is, oldest first, so the latest version
+// of the copy is the latest version of the original.
+func (c *Client) copyVersions(ctx context.Context, versioned VersionedStore, from string, to string) error {
+	versions, err := versioned.Versions(ctx, from)
+	if err != nil {
+		return err
+	}
+
+	for i := len(versions) - 1; i >= 0; i-- {
+		object, err := versioned.GetVersion(ctx, from, versions[i].VersionID)
+		if err != nil {
+			return err
+		}
+
+		data, err := io.ReadAll(object)
+		object.Close()
+		if err != nil {
+			return err
+		}
+
+		if err = c.putObject(ctx, to, data, contentType(data)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
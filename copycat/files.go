@@ -0,0 +1,83 @@
+package copycat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Describes a file uploaded to an environment.
+type File struct {
+	Name string `json:"name"`
+	ObjectInfo
+}
+
+// Returns the prefix the files uploaded to an environment are stored under.
+func filesPrefix(env string) string {
+	return env + "_uploads/"
+}
+
+// Returns every file uploaded to an environment.
+func (c *Client) ListFiles(ctx context.Context, env string) ([]File, error) {
+	objects, err := c.store.List(ctx, filesPrefix(env))
+	if err != nil {
+		return nil, err
+	}
+
+	files := []File{}
+	for _, object := range objects {
+		files = append(files, File{Name: strings.TrimPrefix(object.Key, filesPrefix(env)), ObjectInfo: object})
+	}
+
+	return files, nil
+}
+
+// Returns the metadata of a file uploaded to an environment.
+func (c *Client) StatFile(ctx context.Context, env string, name string) (ObjectInfo, error) {
+	info, err := c.store.Stat(ctx, filesPrefix(env)+name)
+	if errors.Is(err, ErrNotFound) {
+		return ObjectInfo{}, fmt.Errorf("file %s in environment %s: %w", name, env, ErrNotFound)
+	}
+
+	return info, err
+}
+
+// Returns the contents of a file uploaded to an environment, decrypted if
+// needed.
+func (c *Client) GetFile(ctx context.Context, env string, name string) ([]byte, error) {
+	data, err := c.fetch(ctx, env, filesPrefix(env)+name)
+	if errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("file %s in environment %s: %w", name, env, ErrNotFound)
+	}
+
+	return data, err
+}
+
+// Uploads a file to an environment, replacing any file of the same name.
+func (c *Client) PutFile(ctx context.Context, env string, name string, data []byte) error {
+	return c.upload(ctx, env, filesPrefix(env)+name, data)
+}
+
+// Deletes a file uploaded to an environment.
+func (c *Client) DeleteFile(ctx context.Context, env string, name string) error {
+	if _, err := c.StatFile(ctx, env, name); err != nil {
+		return err
+	}
+
+	return c.store.Delete(ctx, filesPrefix(env)+name)
+}
+
+// Renames a file uploaded to an environment, replacing any file of the new
+// name.
+func (c *Client) MoveFile(ctx context.Context, env string, from string, to string) error {
+	if _, err := c.StatFile(ctx, env, from); err != nil {
+		return err
+	}
+
+	if err := c.copyObject(ctx, filesPrefix(env)+from, filesPrefix(env)+to); err != nil {
+		return err
+	}
+
+	return c.store.Delete(ctx, filesPrefix(env)+from)
+}
@@ -0,0 +1,166 @@
+package copycat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Prefix given to partially written files, which are never listed.
+const fsTempPrefix = ".copycat-tmp-"
+
+// A Store backed by a plain directory, such as a NAS mount or a synced folder.
+// Keys map directly onto paths relative to root, so "<environment>_uploads/"
+// becomes a sub-directory.
+type fsStore struct {
+	root string
+}
+
+// Given a directory, returns a Store rooted at it. The directory must already
+// exist.
+func NewFSStore(root string) (Store, error) {
+	if root == "" {
+		return nil, errors.New("no PATH set for fs backend")
+	}
+
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, fmt.Errorf("error opening fs backend: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("fs backend path %s is not a directory", root)
+	}
+
+	return &fsStore{root: root}, nil
+}
+
+// Resolves a key to its path on disk, refusing keys that would escape root.
+func (s *fsStore) path(key string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(key))
+	if key == "" || filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid key %q", key)
+	}
+
+	return filepath.Join(s.root, clean), nil
+}
+
+func (s *fsStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so readers never observe a partially
+	// written object.
+	tmp, err := os.CreateTemp(filepath.Dir(path), fsTempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *fsStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fsError(key, err)
+	}
+
+	return file, nil
+}
+
+func (s *fsStore) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return ObjectInfo{}, fsError(key, err)
+	}
+	if info.IsDir() {
+		return ObjectInfo{}, fmt.Errorf("%s: %w", key, ErrNotFound)
+	}
+
+	return ObjectInfo{Key: key, Size: info.Size(), LastModified: info.ModTime()}, nil
+}
+
+func (s *fsStore) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+
+	err := filepath.Walk(s.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), fsTempPrefix) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.root, path)
+		if err != nil {
+			return err
+		}
+
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, ObjectInfo{Key: key, Size: info.Size(), LastModified: info.ModTime()})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+
+	return objects, nil
+}
+
+func (s *fsStore) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	// Like S3, deleting a key which does not exist is not an error.
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// Translates "file does not exist" errors into ErrNotFound, leaving other
+// errors untouched.
+func fsError(key string, err error) error {
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%s: %w", key, ErrNotFound)
+	}
+
+	return err
+}
@@ -0,0 +1,266 @@
+package copycat
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Layout of the upload time version IDs of environments archived under the
+// "history/" prefix start with. IDs sort chronologically.
+const HistoryLayout = "20060102T150405.000Z"
+
+// Returns the ID of a version of an environment uploaded at a given time with
+// the given (stored) contents: its upload time, followed by a short hash of its
+// contents. Stores may only keep whole seconds, so the hash tells apart
+// versions uploaded within the same second.
+func historyID(uploaded time.Time, data []byte) string {
+	sum := sha256.Sum256(data)
+
+	return uploaded.UTC().Format(HistoryLayout) + "-" + hex.EncodeToString(sum[:6])
+}
+
+// Returns the upload time of a version ID, including IDs of older versions
+// which consist of the upload time only.
+func historyTime(id string) (time.Time, bool) {
+	if len(id) < len(HistoryLayout) {
+		return time.Time{}, false
+	}
+
+	uploaded, err := time.Parse(HistoryLayout, id[:len(HistoryLayout)])
+
+	return uploaded, err == nil
+}
+
+// Returns the content hash of a version ID, empty for IDs of older versions
+// which consist of the upload time only.
+func historyHash(id string) string {
+	if len(id) <= len(HistoryLayout)+1 {
+		return ""
+	}
+
+	return id[len(HistoryLayout)+1:]
+}
+
+// Returns the prefix prior versions of an environment are archived under, when
+// the store does not keep versions natively.
+func historyPrefix(env string) string {
+	return "history/" + env + "/"
+}
+
+// Returns the store as a VersionedStore, if it is currently keeping versions.
+func (c *Client) versioning(ctx context.Context) (VersionedStore, bool, error) {
+	versioned, ok := c.store.(VersionedStore)
+	if !ok {
+		return nil, false, nil
+	}
+
+	enabled, err := versioned.VersioningEnabled(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("error checking bucket versioning: %w", err)
+	}
+
+	return versioned, enabled, nil
+}
+
+// Returns the metadata of an environment's current version. The environment is
+// looked up by listing rather than Stat, as listings carry sub-second
+// modification times, which version IDs are derived from.
+func (c *Client) currentEnvironment(ctx context.Context, env string) (ObjectInfo, error) {
+	objects, err := c.store.List(ctx, "env_"+env)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	for _, object := range objects {
+		if object.Key == "env_"+env {
+			return object, nil
+		}
+	}
+
+	return ObjectInfo{}, fmt.Errorf("environment %s: %w", env, ErrNotFound)
+}
+
+// Archives the current version of an environment under the "history/" prefix,
+// so it is not lost when overwritten. Does nothing if the store keeps versions
+// natively, or if the environment does not exist yet.
+func (c *Client) archiveEnvironment(ctx context.Context, env string) error {
+	if _, enabled, err := c.versioning(ctx); err != nil || enabled {
+		return err
+	}
+
+	_, id, err := c.currentVersion(ctx, env)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.copyObject(ctx, "env_"+env, historyPrefix(env)+id)
+}
+
+// Returns the key holding the ID of an environment's current version, written
+// when the environment was copied (see copyEnvironment): the copy's
+// modification time is not the version's upload time.
+func versionKey(env string) string {
+	return env + "_version"
+}
+
+// Returns the metadata and version ID of an environment's current version. If
+// the environment was copied, the version keeps its original ID and upload
+// time.
+func (c *Client) currentVersion(ctx context.Context, env string) (ObjectInfo, string, error) {
+	current, err := c.currentEnvironment(ctx, env)
+	if err != nil {
+		return ObjectInfo{}, "", err
+	}
+
+	data, err := c.getObject(ctx, "env_"+env)
+	if err != nil {
+		return ObjectInfo{}, "", err
+	}
+	id := historyID(current.LastModified, data)
+
+	original, err := c.getObject(ctx, versionKey(env))
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return ObjectInfo{}, "", err
+	}
+
+	// Only trusted if it describes the contents actually stored.
+	if uploaded, ok := historyTime(string(original)); ok && historyHash(string(original)) == historyHash(id) {
+		current.LastModified = uploaded
+		id = string(original)
+	}
+
+	return current, id, nil
+}
+
+// Replaces an environment's .env, archiving the version being replaced first.
+func (c *Client) replaceEnvironment(ctx context.Context, env string, data []byte) error {
+	if err := c.archiveEnvironment(ctx, env); err != nil {
+		return err
+	}
+
+	if err := c.upload(ctx, env, "env_"+env, data); err != nil {
+		return err
+	}
+
+	// The original ID recorded when copying no longer describes the .env. It
+	// is only ever recorded when the store does not keep versions.
+	if _, enabled, err := c.versioning(ctx); err != nil || enabled {
+		return err
+	}
+
+	return c.store.Delete(ctx, versionKey(env))
+}
+
+// Returns every version of an environment, newest first.
+func (c *Client) Versions(ctx context.Context, env string) ([]ObjectVersion, error) {
+	versioned, enabled, err := c.versioning(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return versioned.Versions(ctx, "env_"+env)
+	}
+
+	var versions []ObjectVersion
+
+	current, id, err := c.currentVersion(ctx, env)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	if err == nil {
+		versions = append(versions, ObjectVersion{
+			ObjectInfo: current,
+			VersionID:  id,
+			IsLatest:   true,
+		})
+	}
+
+	archived, err := c.store.List(ctx, historyPrefix(env))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, object := range archived {
+		id := strings.TrimPrefix(object.Key, historyPrefix(env))
+		if len(versions) > 0 && id == versions[0].VersionID {
+			continue
+		}
+
+		// The archive's own modification time is when it was archived, the
+		// ID records when the version was originally uploaded.
+		if uploaded, ok := historyTime(id); ok {
+			object.LastModified = uploaded
+		}
+
+		versions = append(versions, ObjectVersion{ObjectInfo: object, VersionID: id})
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].LastModified.After(versions[j].LastModified)
+	})
+
+	return versions, nil
+}
+
+// Returns a given version of an environment as stored, i.e. still encrypted if
+// it was encrypted.
+func (c *Client) getEnvironmentVersion(ctx context.Context, env string, id string) ([]byte, error) {
+	versioned, enabled, err := c.versioning(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if enabled {
+		object, err := versioned.GetVersion(ctx, "env_"+env, id)
+		if err != nil {
+			return nil, err
+		}
+		defer object.Close()
+
+		return io.ReadAll(object)
+	}
+
+	if _, current, err := c.currentVersion(ctx, env); err == nil && current == id {
+		return c.getObject(ctx, "env_"+env)
+	}
+
+	return c.getObject(ctx, historyPrefix(env)+id)
+}
+
+// Returns the contents of a given version of an environment, decrypted if
+// needed.
+func (c *Client) GetEnvironmentVersion(ctx context.Context, env string, id string) ([]byte, error) {
+	data, err := c.getEnvironmentVersion(ctx, env, id)
+	if err != nil {
+		return nil, err
+	}
+
+	enc, err := c.Encryption(ctx, env)
+	if err != nil {
+		return nil, err
+	}
+
+	return enc.Open(data)
+}
+
+// Restores a prior version of an environment. The version being replaced is
+// kept in the environment's history. The version is decrypted and encrypted
+// again, so it is only readable by the environment's current recipients.
+func (c *Client) Rollback(ctx context.Context, env string, id string) error {
+	data, err := c.GetEnvironmentVersion(ctx, env, id)
+	if err != nil {
+		return err
+	}
+
+	return c.replaceEnvironment(ctx, env, data)
+}
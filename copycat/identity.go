@@ -0,0 +1,66 @@
+package copycat
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+// Returns a new random X25519 private key.
+func GenerateIdentity() ([]byte, error) {
+	identity := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(identity); err != nil {
+		return nil, fmt.Errorf("error generating identity: %w", err)
+	}
+
+	return identity, nil
+}
+
+// Returns the public key matching an X25519 private key.
+func PublicKey(identity []byte) ([]byte, error) {
+	return curve25519.X25519(identity, curve25519.Basepoint)
+}
+
+// Reads the X25519 private key stored in the given file.
+func ReadIdentity(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading identity (run copycat keys generate): %w", err)
+	}
+
+	identity, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(identity) != curve25519.ScalarSize {
+		return nil, fmt.Errorf("invalid identity in %s", path)
+	}
+
+	return identity, nil
+}
+
+// Writes a private key, refusing to overwrite an existing one.
+func WriteIdentity(path string, identity []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err = file.WriteString(base64.StdEncoding.EncodeToString(identity) + "\n"); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// Decodes a base64 encoded X25519 public key.
+func ParsePublicKey(key string) ([]byte, error) {
+	public, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil || len(public) != curve25519.PointSize {
+		return nil, fmt.Errorf("invalid public key %q", key)
+	}
+
+	return public, nil
+}
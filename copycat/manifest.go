@@ -0,0 +1,153 @@
+package copycat
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Name of the project manifest, usually checked in at the root of a
+// repository.
+const ManifestName = ".copycat.yml"
+
+// Contents of a project manifest, describing the environment a project uses
+// and where its files are restored to.
+type Manifest struct {
+	// Profile used when none is given, instead of the default profile.
+	Profile string `yaml:"profile,omitempty"`
+
+	// Environment used by commands when none is given, instead of the
+	// profile's.
+	Environment string `yaml:"environment,omitempty"`
+
+	// Local path of the environment's .env file, ".env" if empty.
+	EnvFile string `yaml:"env_file,omitempty"`
+
+	// Files of the environment, and where they are restored to.
+	Files []ManifestFile `yaml:"files,omitempty"`
+
+	// Directory holding the manifest, which relative paths are resolved
+	// against.
+	Dir string `yaml:"-"`
+}
+
+// A file of the environment listed in a manifest.
+type ManifestFile struct {
+	// Name of the file in the environment.
+	Name string `yaml:"name"`
+
+	// Local path of the file, its name if empty.
+	Path string `yaml:"path,omitempty"`
+}
+
+// Returns the local path of the environment's .env file.
+func (m Manifest) EnvPath() string {
+	if m.EnvFile == "" {
+		return m.resolve(".env")
+	}
+
+	return m.resolve(m.EnvFile)
+}
+
+// Returns the local path of a file listed in the manifest.
+func (m Manifest) FilePath(file ManifestFile) string {
+	if file.Path == "" {
+		return m.resolve(file.Name)
+	}
+
+	return m.resolve(file.Path)
+}
+
+// Resolves a path relative to the manifest's directory.
+func (m Manifest) resolve(path string) string {
+	return filepath.Join(m.Dir, path)
+}
+
+// Returns an error if path is absolute, or leads outside of the manifest's
+// directory, so a manifest checked into a repository can only write files
+// within it.
+func checkManifestPath(path string) error {
+	if filepath.IsAbs(path) || filepath.VolumeName(path) != "" {
+		return fmt.Errorf("path %s is absolute, paths must be relative to the manifest", path)
+	}
+
+	if clean := filepath.Clean(path); clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("path %s is outside of the manifest's directory", path)
+	}
+
+	return nil
+}
+
+// Looks for a manifest in dir, then in each of its parents. Returns an error
+// wrapping ErrNotFound if there is none.
+func FindManifest(dir string) (Manifest, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	for {
+		path := filepath.Join(dir, ManifestName)
+		if _, err := os.Stat(path); err == nil {
+			return ReadManifest(path)
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return Manifest{}, fmt.Errorf("%s: %w", ManifestName, ErrNotFound)
+		}
+		dir = parent
+	}
+}
+
+// Reads the manifest at path. Unknown keys, files without a name and local
+// paths outside of the manifest's directory are refused.
+func ReadManifest(path string) (Manifest, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Manifest{}, fmt.Errorf("%s: %w", path, ErrNotFound)
+	}
+	if err != nil {
+		return Manifest{}, fmt.Errorf("error reading manifest: %w", err)
+	}
+
+	var manifest Manifest
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err = decoder.Decode(&manifest); err != nil && !errors.Is(err, io.EOF) {
+		return Manifest{}, fmt.Errorf("error reading manifest %s: %w", path, err)
+	}
+
+	if manifest.EnvFile != "" {
+		if err = checkManifestPath(manifest.EnvFile); err != nil {
+			return Manifest{}, fmt.Errorf("error reading manifest %s: env_file: %w", path, err)
+		}
+	}
+
+	for i, file := range manifest.Files {
+		if file.Name == "" {
+			return Manifest{}, fmt.Errorf("error reading manifest %s: file %d has no name", path, i+1)
+		}
+
+		local := file.Path
+		if local == "" {
+			local = file.Name
+		}
+		if err = checkManifestPath(local); err != nil {
+			return Manifest{}, fmt.Errorf("error reading manifest %s: file %s: %w", path, file.Name, err)
+		}
+	}
+
+	if manifest.Dir, err = filepath.Abs(filepath.Dir(path)); err != nil {
+		return Manifest{}, err
+	}
+
+	return manifest, nil
+}
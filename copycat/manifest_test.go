@@ -0,0 +1,63 @@
+package copycat
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindManifest(t *testing.T) {
+	root := t.TempDir()
+	nested := filepath.Join(root, "apps", "api")
+	if err := os.MkdirAll(nested, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := FindManifest(nested); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected no manifest to be found, got %v", err)
+	}
+
+	data := "profile: work\nfiles:\n  - name: secrets.txt\n  - name: key.pem\n    path: config/../keys/key.pem\n"
+	if err := os.WriteFile(filepath.Join(root, ManifestName), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := FindManifest(nested)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Profile != "work" || manifest.Dir != root {
+		t.Errorf("Unexpected manifest: %+v", manifest)
+	}
+
+	// Relative paths are resolved against the manifest's directory.
+	if path := manifest.EnvPath(); path != filepath.Join(root, ".env") {
+		t.Errorf("Unexpected .env path: %s", path)
+	}
+	if path := manifest.FilePath(manifest.Files[0]); path != filepath.Join(root, "secrets.txt") {
+		t.Errorf("Unexpected file path: %s", path)
+	}
+	if path := manifest.FilePath(manifest.Files[1]); path != filepath.Join(root, "keys", "key.pem") {
+		t.Errorf("Unexpected file path: %s", path)
+	}
+
+	// Unknown keys, unnamed files and paths outside of the manifest's
+	// directory are refused.
+	for _, invalid := range []string{
+		"enviroment: staging\n",
+		"files:\n  - path: secrets.txt\n",
+		"env_file: /etc/app/.env\n",
+		"env_file: ../.env\n",
+		"files:\n  - name: key.pem\n    path: /etc/app/key.pem\n",
+		"files:\n  - name: key.pem\n    path: config/../../key.pem\n",
+		"files:\n  - name: ../key.pem\n",
+	} {
+		if err := os.WriteFile(filepath.Join(root, ManifestName), []byte(invalid), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := FindManifest(nested); err == nil {
+			t.Errorf("Expected %q to be refused", invalid)
+		}
+	}
+}
@@ -0,0 +1,397 @@
+package copycat
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/zalando/go-keyring"
+)
+
+// Returns the directory profiles and the default identity are stored in:
+// COPYCAT_CONFIG_DIR if set, otherwise "copycat" inside XDG_CONFIG_HOME (or
+// os.UserConfigDir). Existing ~/.config/copycat directories keep being used
+// until that directory exists, i.e. on macOS or once XDG_CONFIG_HOME is set
+// elsewhere.
+func ConfigDir() (string, error) {
+	if dir := os.Getenv("COPYCAT_CONFIG_DIR"); dir != "" {
+		return filepath.Abs(dir)
+	}
+
+	config, configErr := os.UserConfigDir()
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		config, configErr = xdg, nil
+	}
+
+	dir := filepath.Join(config, "copycat")
+	if configErr == nil {
+		if _, err := os.Stat(dir); err == nil {
+			return dir, nil
+		}
+	}
+
+	if home, err := os.UserHomeDir(); err == nil {
+		legacy := filepath.Join(home, ".config", "copycat")
+		if _, err := os.Stat(legacy); err == nil {
+			return legacy, nil
+		}
+	}
+
+	if configErr != nil {
+		return "", configErr
+	}
+
+	return dir, nil
+}
+
+// Returns the default location of the private key used to decrypt objects
+// encrypted to a set of recipients.
+func DefaultIdentityFile() (string, error) {
+	dir, err := ConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, ".identity"), nil
+}
+
+// Name of the service secrets are stored under in the OS keyring, with the
+// profile name as the user.
+const KeyringService = "copycat"
+
+// Returned (wrapped) by LoadConfig when the config file holds a plaintext
+// secret and can be read by other users.
+var ErrInsecureProfile = errors.New("profile is accessible by other users")
+
+// Returns the settings of a given profile. Returns an error wrapping
+// ErrNotFound if the profile does not exist, or ErrInsecureProfile if the
+// config file holds a secret and can be read by other users.
+func ReadProfile(name string) (Profile, error) {
+	config, err := LoadConfig()
+	if err != nil {
+		return Profile{}, err
+	}
+
+	profile, ok := config.Profiles[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("profile %s: %w", name, ErrNotFound)
+	}
+
+	return profile, nil
+}
+
+// Returns whether the given file mode grants any access to the group or other
+// users. Always false on Windows, where permissions are not mode bits.
+func InsecurePermissions(mode os.FileMode) bool {
+	return runtime.GOOS != "windows" && mode.Perm()&0077 != 0
+}
+
+// Writes the settings of a given profile, replacing any existing ones. The
+// secret is left out if it is kept in the OS keyring (SecretStore is
+// "keyring").
+func WriteProfile(name string, profile Profile) error {
+	if err := checkProfileName(name); err != nil {
+		return err
+	}
+
+	config, err := readConfig()
+	if err != nil {
+		return err
+	}
+
+	config.Profiles[name] = profile
+
+	return config.Save()
+}
+
+// Stores the secret of a given profile in the OS keyring.
+func SetKeyringSecret(name string, secret string) error {
+	if err := keyring.Set(KeyringService, name, secret); err != nil {
+		return fmt.Errorf("error storing secret in keyring: %w", err)
+	}
+
+	return nil
+}
+
+// Removes the secret of a given profile from the OS keyring, if any.
+func DeleteKeyringSecret(name string) error {
+	if err := keyring.Delete(KeyringService, name); err != nil && !errors.Is(err, keyring.ErrNotFound) {
+		return fmt.Errorf("error removing secret from keyring: %w", err)
+	}
+
+	return nil
+}
+
+// Returns the options described by a given profile. Secrets kept in the OS
+// keyring (SecretStore is "keyring") are fetched from it.
+func ProfileOptions(name string, profile Profile) (Options, error) {
+	secret := profile.Secret
+	if profile.SecretStore == "keyring" {
+		var err error
+		if secret, err = keyring.Get(KeyringService, name); err != nil {
+			return Options{}, fmt.Errorf("error reading secret of profile %s from keyring: %w", name, err)
+		}
+	}
+
+	identityFile := profile.Encryption.IdentityFile
+	if identityFile == "" {
+		var err error
+		if identityFile, err = DefaultIdentityFile(); err != nil {
+			return Options{}, err
+		}
+	}
+
+	trustedDir, err := trustedRecipientsDir(name)
+	if err != nil {
+		return Options{}, err
+	}
+
+	return Options{
+		Backend: profile.Backend,
+		Host:    profile.Host,
+		Key:     profile.Key,
+		Secret:  secret,
+		Bucket:  profile.Bucket,
+		Region:  profile.Region,
+
+		Credentials:        profile.Credentials,
+		CredentialsFile:    profile.CredentialsFile,
+		CredentialsProfile: profile.CredentialsProfile,
+		IAMEndpoint:        profile.IAMEndpoint,
+
+		BucketLookup:       profile.BucketLookup,
+		CABundle:           profile.CABundle,
+		InsecureSkipVerify: profile.InsecureSkipVerify,
+		Proxy:              profile.Proxy,
+		ConnectTimeout:     profile.ConnectTimeout,
+		RequestTimeout:     profile.RequestTimeout,
+
+		Path:         profile.Path,
+		Encrypt:      profile.Encryption.Passphrase,
+		KeyFile:      profile.Encryption.KeyFile,
+		IdentityFile: identityFile,
+
+		TrustedRecipientsDir: trustedDir,
+	}, nil
+}
+
+// Returns the directory holding the trusted copies of the recipients of the
+// environments used through a given profile.
+func trustedRecipientsDir(name string) (string, error) {
+	dir, err := ConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, ".recipients", name), nil
+}
+
+// Returns an error if name cannot be used as a profile name, i.e. it is empty,
+// hidden or a path.
+func checkProfileName(name string) error {
+	if name == "" || strings.HasPrefix(name, ".") || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid profile name %q", name)
+	}
+
+	return nil
+}
+
+// Returns the names of every profile, sorted.
+func ListProfiles() ([]string, error) {
+	config, err := readConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for name := range config.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// Returns the profile chosen with SetDefaultProfile, or "default" if none was.
+func DefaultProfile() (string, error) {
+	config, err := readConfig()
+	if err != nil {
+		return "", err
+	}
+
+	if config.Default == "" {
+		return "default", nil
+	}
+
+	return config.Default, nil
+}
+
+// Persists the profile used when none is given. Returns an error wrapping
+// ErrNotFound if the profile does not exist.
+func SetDefaultProfile(name string) error {
+	config, err := readConfig()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := config.Profiles[name]; !ok {
+		return fmt.Errorf("profile %s: %w", name, ErrNotFound)
+	}
+
+	config.Default = name
+
+	return config.Save()
+}
+
+// Copies a given profile (including a secret kept in the OS keyring) to a new
+// name. Returns an error wrapping ErrNotFound if the profile does not exist, or
+// ErrConflict if the new one already does.
+func CopyProfile(name string, newName string) error {
+	config, err := readConfig()
+	if err != nil {
+		return err
+	}
+
+	if err = copyProfile(config, name, newName); err != nil {
+		return err
+	}
+
+	return config.Save()
+}
+
+// Copies a given profile within config, see CopyProfile.
+func copyProfile(config *Config, name string, newName string) error {
+	if err := checkProfileName(newName); err != nil {
+		return err
+	}
+
+	profile, ok := config.Profiles[name]
+	if !ok {
+		return fmt.Errorf("profile %s: %w", name, ErrNotFound)
+	}
+	if _, ok := config.Profiles[newName]; ok {
+		return fmt.Errorf("profile %s: %w", newName, ErrConflict)
+	}
+
+	if profile.SecretStore == "keyring" {
+		secret, err := keyring.Get(KeyringService, name)
+		if err != nil {
+			return fmt.Errorf("error reading secret of profile %s from keyring: %w", name, err)
+		}
+		if err = SetKeyringSecret(newName, secret); err != nil {
+			return err
+		}
+	}
+
+	config.Profiles[newName] = profile
+
+	return nil
+}
+
+// Renames a given profile, remaining the default profile if it was. Returns an
+// error wrapping ErrNotFound if the profile does not exist, or ErrConflict if
+// the new one already does.
+func RenameProfile(name string, newName string) error {
+	config, err := readConfig()
+	if err != nil {
+		return err
+	}
+
+	if err = copyProfile(config, name, newName); err != nil {
+		return err
+	}
+	if err = deleteProfile(config, name); err != nil {
+		return err
+	}
+
+	if config.Default == name {
+		config.Default = newName
+	}
+
+	if err = config.Save(); err != nil {
+		return err
+	}
+
+	// Keeps trusting the same recipients under the new name.
+	from, err := trustedRecipientsDir(name)
+	if err != nil {
+		return err
+	}
+	to, err := trustedRecipientsDir(newName)
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(from, to); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// Deletes a given profile, alongside its secret in the OS keyring. If it was
+// the default profile, "default" is used again. Returns an error wrapping
+// ErrNotFound if the profile does not exist.
+func DeleteProfile(name string) error {
+	config, err := readConfig()
+	if err != nil {
+		return err
+	}
+
+	if err = deleteProfile(config, name); err != nil {
+		return err
+	}
+
+	if config.Default == name {
+		config.Default = ""
+	}
+
+	if err = config.Save(); err != nil {
+		return err
+	}
+
+	dir, err := trustedRecipientsDir(name)
+	if err != nil {
+		return err
+	}
+
+	return os.RemoveAll(dir)
+}
+
+// Deletes a given profile from config, see DeleteProfile.
+func deleteProfile(config *Config, name string) error {
+	profile, ok := config.Profiles[name]
+	if !ok {
+		return fmt.Errorf("profile %s: %w", name, ErrNotFound)
+	}
+
+	if profile.SecretStore == "keyring" {
+		if err := DeleteKeyringSecret(name); err != nil {
+			return err
+		}
+	}
+
+	delete(config.Profiles, name)
+
+	return nil
+}
+
+// Returns a Client for the storage described by a given profile. Encrypting
+// with a passphrase requires the Passphrase option, so profiles using one
+// should be loaded with ReadProfile, ProfileOptions and New instead.
+func NewFromProfile(name string) (*Client, error) {
+	profile, err := ReadProfile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err := ProfileOptions(name, profile)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(opts)
+}
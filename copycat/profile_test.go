@@ -0,0 +1,145 @@
+package copycat
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigDir(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("COPYCAT_CONFIG_DIR", "")
+
+	expect := func(expected string) {
+		t.Helper()
+		if dir, err := ConfigDir(); err != nil || dir != expected {
+			t.Errorf("Expected config dir %s, got %s (%v)", expected, dir, err)
+		}
+	}
+
+	// An existing ~/.config/copycat is kept regardless of the platform.
+	legacy := filepath.Join(home, ".config", "copycat")
+	if err := os.MkdirAll(legacy, 0700); err != nil {
+		t.Fatal(err)
+	}
+	expect(legacy)
+
+	// Including when XDG_CONFIG_HOME is set elsewhere, until copycat exists
+	// there.
+	xdg := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", xdg)
+	expect(legacy)
+	if err := os.MkdirAll(filepath.Join(xdg, "copycat"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	expect(filepath.Join(xdg, "copycat"))
+
+	// Relative directories are resolved, so they keep working after a chdir.
+	t.Setenv("COPYCAT_CONFIG_DIR", "project")
+	wd, _ := os.Getwd()
+	expect(filepath.Join(wd, "project"))
+
+	override := t.TempDir()
+	t.Setenv("COPYCAT_CONFIG_DIR", override)
+	expect(override)
+
+	if err := WriteProfile("default", Profile{Backend: "fs", Path: "/tmp"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(override, ConfigFile)); err != nil {
+		t.Errorf("Profile was not written to the config dir: %v", err)
+	}
+	if identity, _ := DefaultIdentityFile(); identity != filepath.Join(override, ".identity") {
+		t.Errorf("Unexpected identity file %s", identity)
+	}
+}
+
+func TestMigrateConfig(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("COPYCAT_CONFIG_DIR", dir)
+
+	os.WriteFile(filepath.Join(dir, "default"), []byte("BACKEND=\"s3\"\nHOSTNAME=\"https://s3.test\"\nKEY=\"key\"\nSECRET=\"secret\"\nBUCKET=\"copycat\"\nREQUEST_TIMEOUT=\"30s\"\nINSECURE_SKIP_VERIFY=\"true\"\n"), 0600)
+	os.WriteFile(filepath.Join(dir, "nas"), []byte("BACKEND=fs\nPATH=/mnt/nas\nENCRYPTION_KEY_FILE=/etc/copycat.key\n"), 0600)
+	os.WriteFile(filepath.Join(dir, ".default"), []byte("nas\n"), 0600)
+	os.WriteFile(filepath.Join(dir, ".identity"), []byte("identity"), 0600)
+	os.WriteFile(filepath.Join(dir, ".update-check.json"), []byte(`{"latest": "v1.5.0"}`), 0600)
+
+	config, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &Config{
+		Default: "nas",
+		Profiles: map[string]Profile{
+			"default": {Backend: "s3", Host: "https://s3.test", Key: "key", Secret: "secret", Bucket: "copycat", RequestTimeout: 30 * time.Second, InsecureSkipVerify: true},
+			"nas":     {Backend: "fs", Path: "/mnt/nas", Encryption: ProfileEncryption{KeyFile: "/etc/copycat.key"}},
+		},
+	}
+	if !reflect.DeepEqual(config, expected) {
+		t.Errorf("Unexpected migrated config: %+v", config)
+	}
+
+	// The old files are replaced by the config file, other files are kept.
+	for name, exists := range map[string]bool{"default": false, "nas": false, ".default": false, ".identity": true, ".update-check.json": true, ConfigFile: true} {
+		if _, err := os.Stat(filepath.Join(dir, name)); (err == nil) != exists {
+			t.Errorf("Expected %s to exist: %v, got %v", name, exists, err)
+		}
+	}
+
+	// Reading it back yields the same config.
+	if config, err = LoadConfig(); err != nil || !reflect.DeepEqual(config, expected) {
+		t.Errorf("Unexpected config: %+v (%v)", config, err)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, ConfigFile))
+	if !strings.Contains(string(data), "request_timeout: 30s") {
+		t.Errorf("Unexpected config file:\n%s", data)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("COPYCAT_CONFIG_DIR", dir)
+	path := filepath.Join(dir, ConfigFile)
+
+	// Without any profile, nothing is written.
+	if config, err := LoadConfig(); err != nil || len(config.Profiles) != 0 {
+		t.Errorf("Unexpected empty config: %+v (%v)", config, err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected no config file, got %v", err)
+	}
+
+	os.WriteFile(path, []byte("profiles:\n  default:\n    backend: fs\n    pth: /tmp\n"), 0600)
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "pth") {
+		t.Errorf("Expected unknown keys to be refused, got %v", err)
+	}
+
+	os.WriteFile(path, []byte("profiles:\n  default:\n    request_timeout: soon\n"), 0600)
+	if _, err := LoadConfig(); err == nil {
+		t.Errorf("Expected invalid durations to be refused")
+	}
+
+	os.WriteFile(path, []byte("profiles:\n  default:\n    secret: secret\n"), 0600)
+	os.Chmod(path, 0644)
+	if _, err := ReadProfile("default"); !errors.Is(err, ErrInsecureProfile) {
+		t.Errorf("Expected ErrInsecureProfile, got %v", err)
+	}
+	if _, err := ReadProfile("missing"); !errors.Is(err, ErrInsecureProfile) {
+		t.Errorf("Expected ErrInsecureProfile, got %v", err)
+	}
+
+	// Writing a profile tightens the permissions.
+	if err := WriteProfile("default", Profile{Backend: "fs", Path: "/tmp", Environment: "staging"}); err != nil {
+		t.Fatal(err)
+	}
+	if profile, err := ReadProfile("default"); err != nil || profile.Environment != "staging" {
+		t.Errorf("Unexpected profile: %+v (%v)", profile, err)
+	}
+}
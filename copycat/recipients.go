@@ -0,0 +1,245 @@
+package copycat
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Returned (wrapped) when uploading to an environment which lists recipients
+// that were not trusted on this machine, see TrustRecipients.
+var ErrUntrustedRecipients = errors.New("untrusted recipients")
+
+// Returns the public keys an environment is encrypted to, stored under
+// "<environment>_recipients". Returns nil if the environment has none.
+func (c *Client) Recipients(ctx context.Context, env string) ([][]byte, error) {
+	data, err := c.getObject(ctx, env+"_recipients")
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var recipients [][]byte
+	for _, line := range strings.Fields(string(data)) {
+		public, err := ParsePublicKey(line)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, public)
+	}
+
+	return recipients, nil
+}
+
+// Replaces the public keys an environment is encrypted to, then re-encrypts the
+// environment and all of its files to the new recipients, so removed keys can
+// no longer decrypt future versions.
+func (c *Client) SetRecipients(ctx context.Context, env string, recipients [][]byte) error {
+	if len(recipients) == 0 {
+		return errors.New("an environment needs at least one recipient")
+	}
+
+	if _, err := c.StatEnvironment(ctx, env); err != nil {
+		return err
+	}
+
+	current, err := c.Encryption(ctx, env)
+	if err != nil {
+		return err
+	}
+
+	updated := current
+	updated.Enabled = true
+	updated.Recipients = recipients
+
+	files, err := c.ListFiles(ctx, env)
+	if err != nil {
+		return err
+	}
+
+	keys := []string{"env_" + env}
+	for _, file := range files {
+		keys = append(keys, file.Key)
+	}
+
+	for _, key := range keys {
+		if err = c.reencrypt(ctx, key, current, updated); err != nil {
+			return err
+		}
+	}
+
+	// Written last, so the list is never updated unless every object was
+	// re-encrypted. Objects encrypted to either list can still be decrypted
+	// if this fails partway.
+	if err = c.putObject(ctx, env+"_recipients", encodeRecipients(recipients), "text/plain"); err != nil {
+		return err
+	}
+
+	return c.writeTrustedRecipients(env, recipients)
+}
+
+// Returns a recipient list as stored, one base64-encoded key per line.
+func encodeRecipients(recipients [][]byte) []byte {
+	var data bytes.Buffer
+	for _, recipient := range recipients {
+		data.WriteString(base64.StdEncoding.EncodeToString(recipient) + "\n")
+	}
+
+	return data.Bytes()
+}
+
+// Returns the path of the trusted copy of an environment's recipients, or an
+// empty string if recipients are not verified.
+func (c *Client) trustedRecipientsPath(env string) string {
+	if c.opts.TrustedRecipientsDir == "" {
+		return ""
+	}
+
+	return filepath.Join(c.opts.TrustedRecipientsDir, url.PathEscape(env))
+}
+
+// Reads the trusted copy of an environment's recipients. Returns false if
+// there is none yet.
+func (c *Client) trustedRecipients(env string) ([][]byte, bool, error) {
+	data, err := os.ReadFile(c.trustedRecipientsPath(env))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("error reading trusted recipients: %w", err)
+	}
+
+	var recipients [][]byte
+	for _, line := range strings.Fields(string(data)) {
+		public, err := ParsePublicKey(line)
+		if err != nil {
+			return nil, false, err
+		}
+		recipients = append(recipients, public)
+	}
+
+	return recipients, true, nil
+}
+
+// Replaces the trusted copy of an environment's recipients, if recipients are
+// verified.
+func (c *Client) writeTrustedRecipients(env string, recipients [][]byte) error {
+	path := c.trustedRecipientsPath(env)
+	if path == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, encodeRecipients(recipients), 0600)
+}
+
+// Returns the recipients an environment lists which are missing from its
+// trusted copy, i.e. added by a teammate, or by someone with access to the
+// bucket. Returns nil if recipients are not verified, or if the environment
+// was never seen on this machine.
+func (c *Client) UntrustedRecipients(ctx context.Context, env string) ([][]byte, error) {
+	recipients, err := c.Recipients(ctx, env)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.untrusted(env, recipients)
+}
+
+// Returns the recipients missing from an environment's trusted copy, see
+// UntrustedRecipients.
+func (c *Client) untrusted(env string, recipients [][]byte) ([][]byte, error) {
+	if c.trustedRecipientsPath(env) == "" {
+		return nil, nil
+	}
+
+	trusted, ok, err := c.trustedRecipients(env)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	var untrusted [][]byte
+	for _, recipient := range recipients {
+		if !ContainsKey(trusted, recipient) {
+			untrusted = append(untrusted, recipient)
+		}
+	}
+
+	return untrusted, nil
+}
+
+// Trusts every recipient an environment currently lists, once they have been
+// reviewed.
+func (c *Client) TrustRecipients(ctx context.Context, env string) error {
+	recipients, err := c.Recipients(ctx, env)
+	if err != nil {
+		return err
+	}
+
+	return c.writeTrustedRecipients(env, recipients)
+}
+
+// Returns an error wrapping ErrUntrustedRecipients if recipients holds keys
+// missing from the environment's trusted copy. The first list seen for an
+// environment is trusted.
+func (c *Client) checkRecipients(env string, recipients [][]byte) error {
+	if len(recipients) == 0 || c.trustedRecipientsPath(env) == "" {
+		return nil
+	}
+
+	if _, ok, err := c.trustedRecipients(env); err != nil {
+		return err
+	} else if !ok {
+		return c.writeTrustedRecipients(env, recipients)
+	}
+
+	untrusted, err := c.untrusted(env, recipients)
+	if err != nil {
+		return err
+	}
+	if len(untrusted) > 0 {
+		return fmt.Errorf("%w: %s lists %d recipient(s) not added from this machine", ErrUntrustedRecipients, env, len(untrusted))
+	}
+
+	return nil
+}
+
+// Returns whether keys holds key, i.e. whether a public key is one of an
+// environment's recipients.
+func ContainsKey(keys [][]byte, key []byte) bool {
+	for _, k := range keys {
+		if bytes.Equal(k, key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Decrypts a single object using from, and encrypts it again using to.
+func (c *Client) reencrypt(ctx context.Context, key string, from Encryption, to Encryption) error {
+	data, err := c.getObject(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	if data, err = from.Open(data); err != nil {
+		return err
+	}
+	if data, err = to.Seal(data); err != nil {
+		return err
+	}
+
+	return c.putObject(ctx, key, data, contentType(data))
+}
@@ -0,0 +1,374 @@
+package copycat
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// Returned (wrapped) by a Store whenever the requested key does not exist, and
+// by a Client whenever the requested environment or file does not exist.
+var ErrNotFound = errors.New("not found")
+
+// Returned (wrapped) by a Client when an operation would overwrite an existing
+// environment or file.
+var ErrConflict = errors.New("already exists")
+
+// Describes a single object held by a Store.
+type ObjectInfo struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+	ETag         string    `json:"etag,omitempty"`
+}
+
+// Store is the storage backend CopyCat talks to. Every environment and file is
+// addressed by a flat key, i.e. "env_<environment>" for the .env file itself
+// and "<environment>_uploads/<file>" for uploaded files.
+type Store interface {
+	// Uploads size bytes read from r under the given key, replacing any
+	// existing object.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+
+	// Returns a reader for the object stored under key. The caller is
+	// responsible for closing it.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+
+	// Returns the metadata of the object stored under key.
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+
+	// Returns every object whose key starts with prefix.
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+
+	// Removes the object stored under key.
+	Delete(ctx context.Context, key string) error
+}
+
+// Describes a single version of an object.
+type ObjectVersion struct {
+	ObjectInfo
+
+	VersionID string `json:"version_id"`
+	IsLatest  bool   `json:"is_latest"`
+}
+
+// VersionedStore is implemented by stores which can natively keep prior
+// versions of objects, i.e. S3 buckets with versioning enabled.
+type VersionedStore interface {
+	// Returns whether prior versions are currently being kept.
+	VersioningEnabled(ctx context.Context) (bool, error)
+
+	// Returns every version of the object stored under key, newest first.
+	Versions(ctx context.Context, key string) ([]ObjectVersion, error)
+
+	// Returns a reader for the given version of the object stored under key.
+	GetVersion(ctx context.Context, key string, versionID string) (io.ReadCloser, error)
+
+	// Permanently removes every version of the object stored under key,
+	// including delete markers. Keys ending with a slash remove every object
+	// below them instead.
+	DeleteVersions(ctx context.Context, key string) error
+}
+
+// Returns a new minio client for the bucket described by opts (i.e. Host, Key,
+// Secret and Region). Hosts starting with "http://" are connected to without
+// TLS.
+func NewS3Client(opts Options) (*minio.Client, error) {
+	useSSL := true
+	var endpoint string
+
+	if strings.Contains(opts.Host, "http://") {
+		useSSL = false
+		endpoint = strings.Replace(opts.Host, "http://", "", 1)
+	} else {
+		endpoint = strings.Replace(opts.Host, "https://", "", 1)
+	}
+
+	creds, err := newCredentials(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	transport, err := newTransport(opts, useSSL)
+	if err != nil {
+		return nil, err
+	}
+
+	lookups := map[string]minio.BucketLookupType{
+		"":     minio.BucketLookupAuto,
+		"auto": minio.BucketLookupAuto,
+		"dns":  minio.BucketLookupDNS,
+		"path": minio.BucketLookupPath,
+	}
+	lookup, ok := lookups[opts.BucketLookup]
+	if !ok {
+		return nil, fmt.Errorf("unknown bucket lookup %q, expected dns, path or auto", opts.BucketLookup)
+	}
+
+	// Initialize minio client object.
+	minioClient, err := minio.New(endpoint, &minio.Options{
+		Creds:        creds,
+		Secure:       useSSL,
+		Transport:    transport,
+		Region:       opts.Region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return minioClient, nil
+}
+
+// Returns the HTTP transport described by opts (i.e. CABundle, Proxy or the
+// timeouts), based on minio's default transport.
+func newTransport(opts Options, secure bool) (*http.Transport, error) {
+	transport, err := minio.DefaultTransport(secure)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Proxy != "" {
+		proxy, err := url.Parse(opts.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	if opts.ConnectTimeout > 0 {
+		transport.DialContext = (&net.Dialer{Timeout: opts.ConnectTimeout, KeepAlive: 30 * time.Second}).DialContext
+		transport.TLSHandshakeTimeout = opts.ConnectTimeout
+	}
+	if opts.RequestTimeout > 0 {
+		transport.ResponseHeaderTimeout = opts.RequestTimeout
+	}
+
+	if !secure {
+		return transport, nil
+	}
+
+	if opts.CABundle != "" {
+		data, err := os.ReadFile(opts.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA bundle: %w", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", opts.CABundle)
+		}
+
+		transport.TLSClientConfig.RootCAs = pool
+	}
+
+	transport.TLSClientConfig.InsecureSkipVerify = opts.InsecureSkipVerify
+
+	return transport, nil
+}
+
+// Returns the credentials described by opts.Credentials. Every source but
+// static is refreshed once the credentials it provided expire.
+func newCredentials(opts Options) (*credentials.Credentials, error) {
+	file := &credentials.FileAWSCredentials{Filename: opts.CredentialsFile, Profile: opts.CredentialsProfile}
+	iam := &credentials.IAM{Client: &http.Client{Transport: http.DefaultTransport}, Endpoint: opts.IAMEndpoint}
+
+	switch opts.Credentials {
+	case "", "static":
+		return credentials.NewStaticV4(opts.Key, opts.Secret, ""), nil
+	case "env":
+		return credentials.NewEnvAWS(), nil
+	case "file":
+		return credentials.New(file), nil
+	case "iam":
+		return credentials.New(iam), nil
+	case "chain":
+		return credentials.NewChainCredentials([]credentials.Provider{&credentials.EnvAWS{}, file, iam}), nil
+	default:
+		return nil, fmt.Errorf("unknown credentials %q", opts.Credentials)
+	}
+}
+
+// Returns a Store backed by the given bucket. If the bucket has versioning
+// enabled, the Store keeps prior versions natively (see VersionedStore).
+func NewS3Store(client *minio.Client, bucket string) Store {
+	return &minioStore{client: client, bucket: bucket}
+}
+
+// A Store backed by any S3-compliant bucket.
+type minioStore struct {
+	client *minio.Client
+	bucket string
+}
+
+func (s *minioStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	_, err := s.client.PutObject(ctx, s.bucket, key, r, size, minio.PutObjectOptions{ContentType: contentType})
+
+	return err
+}
+
+func (s *minioStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	object, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, minioError(key, err)
+	}
+
+	// GetObject is lazy, so stat the object to surface missing keys now rather
+	// than on the first read.
+	if _, err := object.Stat(); err != nil {
+		object.Close()
+		return nil, minioError(key, err)
+	}
+
+	return object, nil
+}
+
+func (s *minioStore) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	info, err := s.client.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return ObjectInfo{}, minioError(key, err)
+	}
+
+	return minioObjectInfo(info), nil
+}
+
+func (s *minioStore) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	defer cancel()
+
+	objectCh := s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+	})
+
+	var objects []ObjectInfo
+
+	for object := range objectCh {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		objects = append(objects, minioObjectInfo(object))
+	}
+
+	return objects, nil
+}
+
+func (s *minioStore) Delete(ctx context.Context, key string) error {
+	return s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+}
+
+func (s *minioStore) VersioningEnabled(ctx context.Context) (bool, error) {
+	config, err := s.client.GetBucketVersioning(ctx, s.bucket)
+	if err != nil {
+		return false, err
+	}
+
+	return config.Enabled(), nil
+}
+
+func (s *minioStore) Versions(ctx context.Context, key string) ([]ObjectVersion, error) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	defer cancel()
+
+	objectCh := s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+		Prefix:       key,
+		Recursive:    true,
+		WithVersions: true,
+	})
+
+	var versions []ObjectVersion
+
+	for object := range objectCh {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		if object.Key != key || object.IsDeleteMarker {
+			continue
+		}
+		versions = append(versions, ObjectVersion{
+			ObjectInfo: minioObjectInfo(object),
+			VersionID:  object.VersionID,
+			IsLatest:   object.IsLatest,
+		})
+	}
+
+	return versions, nil
+}
+
+func (s *minioStore) GetVersion(ctx context.Context, key string, versionID string) (io.ReadCloser, error) {
+	object, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{VersionID: versionID})
+	if err != nil {
+		return nil, minioError(key, err)
+	}
+
+	if _, err := object.Stat(); err != nil {
+		object.Close()
+		return nil, minioError(key, err)
+	}
+
+	return object, nil
+}
+
+func (s *minioStore) DeleteVersions(ctx context.Context, key string) error {
+	ctx, cancel := context.WithCancel(ctx)
+
+	defer cancel()
+
+	objectCh := s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+		Prefix:       key,
+		Recursive:    true,
+		WithVersions: true,
+	})
+
+	for object := range objectCh {
+		if object.Err != nil {
+			return object.Err
+		}
+		if object.Key != key && !strings.HasSuffix(key, "/") {
+			continue
+		}
+
+		err := s.client.RemoveObject(ctx, s.bucket, object.Key, minio.RemoveObjectOptions{VersionID: object.VersionID})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Converts minio's object metadata to an ObjectInfo.
+func minioObjectInfo(info minio.ObjectInfo) ObjectInfo {
+	return ObjectInfo{
+		Key:          info.Key,
+		Size:         info.Size,
+		LastModified: info.LastModified,
+		ETag:         info.ETag,
+	}
+}
+
+// Translates "no such key" responses into ErrNotFound, leaving other errors
+// untouched.
+func minioError(key string, err error) error {
+	if code := minio.ToErrorResponse(err).Code; code == "NoSuchKey" || code == "NoSuchVersion" {
+		return fmt.Errorf("%s: %w", key, ErrNotFound)
+	}
+
+	return err
+}
@@ -0,0 +1,262 @@
+package copycat
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// In-memory Store, used to exercise commands without a live bucket.
+type memStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newMemStore() *memStore {
+	return &memStore{objects: map[string][]byte{}}
+}
+
+func (s *memStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = data
+
+	return nil
+}
+
+func (s *memStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.objects[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *memStore) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.objects[key]
+	if !ok {
+		return ObjectInfo{}, ErrNotFound
+	}
+
+	return ObjectInfo{Key: key, Size: int64(len(data)), LastModified: time.Now()}, nil
+}
+
+func (s *memStore) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var objects []ObjectInfo
+	for key, data := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, ObjectInfo{Key: key, Size: int64(len(data))})
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+
+	return objects, nil
+}
+
+func (s *memStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, key)
+
+	return nil
+}
+
+// In-memory VersionedStore, keeping every version of each object like a bucket
+// with versioning enabled. Deleting an object adds a delete marker (nil).
+type versionedMemStore struct {
+	mu       sync.Mutex
+	versions map[string][][]byte
+}
+
+func newVersionedMemStore() *versionedMemStore {
+	return &versionedMemStore{versions: map[string][][]byte{}}
+}
+
+func (s *versionedMemStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.versions[key] = append(s.versions[key], data)
+
+	return nil
+}
+
+func (s *versionedMemStore) latest(key string) ([]byte, bool) {
+	versions := s.versions[key]
+	if len(versions) == 0 || versions[len(versions)-1] == nil {
+		return nil, false
+	}
+
+	return versions[len(versions)-1], true
+}
+
+func (s *versionedMemStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.latest(key)
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *versionedMemStore) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.latest(key)
+	if !ok {
+		return ObjectInfo{}, ErrNotFound
+	}
+
+	return ObjectInfo{Key: key, Size: int64(len(data))}, nil
+}
+
+func (s *versionedMemStore) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var objects []ObjectInfo
+	for key := range s.versions {
+		if data, ok := s.latest(key); ok && strings.HasPrefix(key, prefix) {
+			objects = append(objects, ObjectInfo{Key: key, Size: int64(len(data))})
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+
+	return objects, nil
+}
+
+func (s *versionedMemStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.versions[key] = append(s.versions[key], nil)
+
+	return nil
+}
+
+func (s *versionedMemStore) VersioningEnabled(ctx context.Context) (bool, error) {
+	return true, nil
+}
+
+func (s *versionedMemStore) Versions(ctx context.Context, key string) ([]ObjectVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var versions []ObjectVersion
+	for i := len(s.versions[key]) - 1; i >= 0; i-- {
+		if data := s.versions[key][i]; data != nil {
+			versions = append(versions, ObjectVersion{
+				ObjectInfo: ObjectInfo{Key: key, Size: int64(len(data))},
+				VersionID:  strconv.Itoa(i),
+				IsLatest:   i == len(s.versions[key])-1,
+			})
+		}
+	}
+
+	return versions, nil
+}
+
+func (s *versionedMemStore) GetVersion(ctx context.Context, key string, versionID string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, err := strconv.Atoi(versionID)
+	if err != nil || i < 0 || i >= len(s.versions[key]) || s.versions[key][i] == nil {
+		return nil, ErrNotFound
+	}
+
+	return io.NopCloser(bytes.NewReader(s.versions[key][i])), nil
+}
+
+func (s *versionedMemStore) DeleteVersions(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k := range s.versions {
+		if k == key || (strings.HasSuffix(key, "/") && strings.HasPrefix(k, key)) {
+			delete(s.versions, k)
+		}
+	}
+
+	return nil
+}
+
+func TestFSStore(t *testing.T) {
+	store, err := NewFSStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	objects := map[string]string{
+		"env_staging":             "KEY=value\n",
+		"staging_uploads/a.txt":   "a",
+		"staging_uploads/b/c.txt": "c",
+	}
+
+	for key, data := range objects {
+		if err := store.Put(ctx, key, strings.NewReader(data), int64(len(data)), "text/plain"); err != nil {
+			t.Fatalf("Error uploading %s: %s", key, err)
+		}
+	}
+
+	envs, err := store.List(ctx, "env_")
+	if err != nil || len(envs) != 1 || envs[0].Key != "env_staging" {
+		t.Errorf("Unexpected environments: %v (%v)", envs, err)
+	}
+
+	files, err := store.List(ctx, "staging_uploads/")
+	if err != nil || len(files) != 2 || files[1].Key != "staging_uploads/b/c.txt" {
+		t.Errorf("Unexpected files: %v (%v)", files, err)
+	}
+
+	object, err := store.Get(ctx, "staging_uploads/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(object)
+	object.Close()
+	if string(data) != "a" {
+		t.Errorf("Downloaded data does not equal uploaded data.")
+	}
+
+	if err := store.Delete(ctx, "staging_uploads/a.txt"); err != nil {
+		t.Errorf("Error deleting file: %s", err)
+	}
+	if _, err := store.Stat(ctx, "staging_uploads/a.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	if _, err := store.Get(ctx, "../outside"); err == nil {
+		t.Errorf("Expected keys escaping the root to be rejected")
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/joho/godotenv"
+)
+
+// Describes how a single key differs between two environments.
+type keyChange struct {
+	key    string
+	kind   byte // '+' (added), '-' (removed) or '~' (changed)
+	before string
+	after  string
+}
+
+// Returns every key added, removed or changed going from one parsed
+// environment to another, sorted by key.
+func diffEnvs(from map[string]string, to map[string]string) []keyChange {
+	var changes []keyChange
+
+	for key, before := range from {
+		after, exists := to[key]
+		if !exists {
+			changes = append(changes, keyChange{key: key, kind: '-', before: before})
+		} else if after != before {
+			changes = append(changes, keyChange{key: key, kind: '~', before: before, after: after})
+		}
+	}
+
+	for key, after := range to {
+		if _, exists := from[key]; !exists {
+			changes = append(changes, keyChange{key: key, kind: '+', after: after})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].key < changes[j].key })
+
+	return changes
+}
+
+// Given an array which may contain the following:
+//   - 0: environment
+//   - 1: other environment
+//
+// prints the keys which differ between the local .env and the environment (i.e.
+// what downloading it would change), or between both environments. Values are
+// masked unless --show-values is given.
+func diff(args []string) error {
+	flags := flag.NewFlagSet("diff", flag.ContinueOnError)
+	showValues := flags.Bool("show-values", false, "print the values of changed keys")
+	args, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if len(args) != 1 && len(args) != 2 {
+		return usage("Expected 1 or 2 argument(s), got "+fmt.Sprint(len(args)), false)
+	}
+
+	client, err := getClient()
+	if err != nil {
+		return err
+	}
+
+	remote, err := client.ParseEnvironment(context.Background(), args[0])
+	if err != nil {
+		return err
+	}
+
+	from, to, fromName, toName := map[string]string{}, remote, "./.env", args[0]
+
+	if len(args) == 2 {
+		if to, err = client.ParseEnvironment(context.Background(), args[1]); err != nil {
+			return err
+		}
+		from, fromName, toName = remote, args[0], args[1]
+	} else if data, err := os.ReadFile("./.env"); err == nil {
+		if from, err = godotenv.Parse(bytes.NewReader(data)); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	changes := diffEnvs(from, to)
+
+	if jsonOutput() {
+		return emitChanges(fromName, toName, changes, *showValues)
+	}
+
+	fmt.Println(White(fromName + " -> " + toName + ":"))
+
+	if len(changes) == 0 {
+		fmt.Println("... " + OK("No differences!"))
+		return nil
+	}
+
+	for _, change := range changes {
+		switch {
+		case change.kind == '+' && *showValues:
+			fmt.Println(OK("+ " + change.key + "=" + change.after))
+		case change.kind == '-' && *showValues:
+			fmt.Println(Fata("- " + change.key + "=" + change.before))
+		case change.kind == '~' && *showValues:
+			fmt.Println(Warn("~ "+change.key+": ") + change.before + " -> " + change.after)
+		case change.kind == '+':
+			fmt.Println(OK("+ " + change.key))
+		case change.kind == '-':
+			fmt.Println(Fata("- " + change.key))
+		default:
+			fmt.Println(Warn("~ " + change.key))
+		}
+	}
+
+	return nil
+}
+
+// Describes a changed key in JSON output.
+type changeInfo struct {
+	Key    string `json:"key"`
+	Change string `json:"change"`
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+}
+
+// Writes the changes between two environments as a JSON document. Values are
+// only included if showValues is set.
+func emitChanges(fromName string, toName string, changes []keyChange, showValues bool) error {
+	infos := []changeInfo{}
+	kinds := map[byte]string{'+': "added", '-': "removed", '~': "changed"}
+
+	for _, change := range changes {
+		info := changeInfo{Key: change.key, Change: kinds[change.kind]}
+		if showValues {
+			info.Before, info.After = change.before, change.after
+		}
+		infos = append(infos, info)
+	}
+
+	return emit(map[string]interface{}{"from": fromName, "to": toName, "changes": infos})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffEnvs(t *testing.T) {
+	from := map[string]string{"KEPT": "1", "CHANGED": "old", "REMOVED": "gone"}
+	to := map[string]string{"KEPT": "1", "CHANGED": "new", "ADDED": "here"}
+
+	expected := []keyChange{
+		{key: "ADDED", kind: '+', after: "here"},
+		{key: "CHANGED", kind: '~', before: "old", after: "new"},
+		{key: "REMOVED", kind: '-', before: "gone"},
+	}
+
+	if changes := diffEnvs(from, to); !reflect.DeepEqual(changes, expected) {
+		t.Errorf("Unexpected changes: %+v", changes)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	testBackends["fs"](t)
+
+	writeFile(t, ".env", "KEPT=1\nCHANGED=remote\n")
+	captureOutput(t, "", func() error { return upload([]string{"staging"}) })
+	writeFile(t, ".env", "KEPT=1\nCHANGED=local\nLOCAL_ONLY=secret\n")
+
+	output := captureOutput(t, "", func() error { return diff([]string{"staging"}) })
+	if !strings.Contains(output, "~ CHANGED") || !strings.Contains(output, "- LOCAL_ONLY") {
+		t.Errorf("Unexpected diff:\n%s", output)
+	}
+	if strings.Contains(output, "secret") || strings.Contains(output, "remote") {
+		t.Errorf("Values were not masked:\n%s", output)
+	}
+
+	output = captureOutput(t, "", func() error { return diff([]string{"staging", "--show-values"}) })
+	if !strings.Contains(output, "local -> remote") {
+		t.Errorf("Values were not shown:\n%s", output)
+	}
+
+	// Comparing two environments ignores the local .env.
+	captureOutput(t, "", func() error { return upload([]string{"production"}) })
+	output = captureOutput(t, "", func() error { return diff([]string{"--show-values", "staging", "production"}) })
+	if !strings.Contains(output, "+ LOCAL_ONLY=secret") || !strings.Contains(output, "remote -> local") {
+		t.Errorf("Unexpected diff:\n%s", output)
+	}
+}
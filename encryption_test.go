@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ghst.fr/matthew/copy-cat-env/copycat"
+)
+
+func TestEncryptedProfile(t *testing.T) {
+	fake := newFakeS3(t, testBucket)
+	keyFile := filepath.Join(t.TempDir(), "key")
+	writeFile(t, keyFile, "correct horse battery staple")
+
+	setupProfile(t, copycat.Profile{
+		Backend:    "s3",
+		Host:       fake.URL,
+		Key:        "key",
+		Secret:     "secret",
+		Bucket:     testBucket,
+		Encryption: copycat.ProfileEncryption{KeyFile: keyFile},
+	})
+
+	writeFile(t, ".env", "SECRET=hunter2\n")
+	captureOutput(t, "", func() error { return upload([]string{"staging"}) })
+
+	if data, ok := fake.object(testBucket, "env_staging"); !ok || !copycat.IsEncrypted(data) {
+		t.Fatalf("Environment was not encrypted before being uploaded")
+	}
+
+	writeFile(t, ".env", "")
+	captureOutput(t, "", func() error { return download([]string{"staging"}) })
+
+	if got := readFile(t, ".env"); got != "SECRET=hunter2\n" {
+		t.Errorf("Downloaded .env does not match: %q", got)
+	}
+}
+
+func TestEncryptedUploadFromStdin(t *testing.T) {
+	if tty, err := openTerminal(); err == nil {
+		tty.Close()
+		t.Skip("a terminal is available, so the passphrase would be prompted for")
+	}
+
+	setupProfile(t, copycat.Profile{Backend: "fs", Path: t.TempDir(), Encryption: copycat.ProfileEncryption{Passphrase: true}})
+
+	// Stdin holds the environment, so the passphrase cannot be read from it.
+	_, err := captureError(t, "SECRET=hunter2\n", func() error { return upload([]string{"staging", "--file", "-"}) })
+	if err == nil || !strings.Contains(err.Error(), "COPYCAT_PASSPHRASE") {
+		t.Errorf("Expected an error mentioning COPYCAT_PASSPHRASE, got %v", err)
+	}
+
+	t.Setenv("COPYCAT_PASSPHRASE", "correct horse battery staple")
+	captureOutput(t, "SECRET=hunter2\n", func() error { return upload([]string{"staging", "--file", "-"}) })
+	if output := captureOutput(t, "", func() error { return download([]string{"staging", "--file", "-"}) }); output != "SECRET=hunter2\n" {
+		t.Errorf("Downloaded environment does not match: %q", output)
+	}
+}
+
+// Creates a new identity in dir, returning its path and public key.
+func newTestIdentity(t *testing.T, dir string, name string) (string, string) {
+	path := filepath.Join(dir, name)
+
+	identity, err := copycat.GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := copycat.WriteIdentity(path, identity); err != nil {
+		t.Fatal(err)
+	}
+
+	public, err := copycat.PublicKey(identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path, base64.StdEncoding.EncodeToString(public)
+}
+
+func TestKeys(t *testing.T) {
+	setupProfile(t, copycat.Profile{Backend: "fs", Path: t.TempDir()})
+
+	writeFile(t, ".env", "SECRET=hunter2\n")
+	writeFile(t, "secrets.txt", "aws secrets")
+	captureOutput(t, "", func() error { return upload([]string{"staging"}) })
+	captureOutput(t, "", func() error { return files([]string{"staging", "upload", "secrets.txt"}) })
+
+	// Generate our own identity, and encrypt the environment to it.
+	output := captureOutput(t, "", func() error { return keys([]string{"generate"}) })
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	ownPublic := lines[len(lines)-1]
+
+	captureOutput(t, "", func() error { return keys([]string{"add", "staging", ownPublic}) })
+
+	client, err := getClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := client.Store()
+
+	for _, key := range []string{"env_staging", "staging_uploads/secrets.txt"} {
+		object, err := store.Get(context.Background(), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(object)
+		object.Close()
+
+		if !copycat.IsEncrypted(data) {
+			t.Errorf("%s was not re-encrypted", key)
+		}
+	}
+
+	os.Remove(".env")
+	captureOutput(t, "", func() error { return download([]string{"staging"}) })
+	if got := readFile(t, ".env"); got != "SECRET=hunter2\n" {
+		t.Errorf("Downloaded .env does not match: %q", got)
+	}
+
+	// Adding, then removing a teammate should revoke their access.
+	teammate, teammatePublic := newTestIdentity(t, t.TempDir(), "teammate")
+	canDecrypt := func() bool {
+		object, err := store.Get(context.Background(), "env_staging")
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(object)
+		object.Close()
+
+		_, err = copycat.Encryption{IdentityFile: teammate}.Open(data)
+		return err == nil
+	}
+
+	captureOutput(t, "", func() error { return keys([]string{"add", "staging", teammatePublic}) })
+	if !canDecrypt() {
+		t.Errorf("Added recipient cannot decrypt the environment")
+	}
+
+	output = captureOutput(t, "", func() error { return keys([]string{"list", "staging"}) })
+	if !strings.Contains(output, ownPublic) || !strings.Contains(output, teammatePublic) {
+		t.Errorf("Recipients not listed:\n%s", output)
+	}
+
+	captureOutput(t, "", func() error { return keys([]string{"remove", "staging", teammatePublic}) })
+	if canDecrypt() {
+		t.Errorf("Removed recipient can still decrypt the environment")
+	}
+}
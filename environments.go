@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"ghst.fr/matthew/copy-cat-env/copycat"
+)
+
+// Returns every key belonging to an environment: the .env itself, its
+// recipients, uploaded files and archived versions. Describes how to list the
+// valid environments if the environment does not exist.
+func environmentKeys(client *copycat.Client, env string) ([]string, error) {
+	keys, err := client.EnvironmentKeys(context.Background(), env)
+	if errors.Is(err, errNotFound) {
+		fmt.Println(Fata("Environment not found. Use ") + Teal("copycat list") + Fata(" to view a list of valid environments."))
+		return nil, shown(err)
+	}
+
+	return keys, err
+}
+
+// Given an array which may contain the following:
+//   - 0: environment to delete
+//   - --yes: skip the confirmation prompt
+//
+// deletes the environment, alongside its files, recipients and history.
+func deleteEnv(args []string) error {
+	flags := flag.NewFlagSet("delete", flag.ContinueOnError)
+	yes := flags.Bool("yes", false, "do not prompt for confirmation")
+	args, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if err := requireArgs(args, 1, true, false); err != nil {
+		return err
+	}
+
+	env := args[0]
+
+	client, err := getClient()
+	if err != nil {
+		return err
+	}
+
+	keys, err := environmentKeys(client, env)
+	if err != nil {
+		return err
+	}
+
+	if !confirm(fmt.Sprintf("Permanently delete environment %s (%d object(s))", env, len(keys)), *yes) {
+		fmt.Println(Fata("Canceled"))
+		return shown(errCanceled)
+	}
+
+	fmt.Print(Teal("Deleting environment " + env + "... "))
+
+	if err = client.DeleteEnvironment(context.Background(), env); err != nil {
+		fmt.Println(Fata("FAILED!"))
+		return err
+	}
+
+	fmt.Println(OK("DONE!"))
+
+	return emit(map[string]interface{}{"environment": env, "deleted": keys})
+}
+
+// Given an array which may contain the following:
+//   - 0: environment to rename
+//   - 1: new name
+//   - --yes: skip the confirmation prompt
+//
+// renames the environment, alongside its files, recipients and history.
+func renameEnv(args []string) error {
+	flags := flag.NewFlagSet("rename", flag.ContinueOnError)
+	yes := flags.Bool("yes", false, "do not prompt for confirmation")
+	args, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if err := requireArgs(args, 2, true, false); err != nil {
+		return err
+	}
+
+	from, to := args[0], args[1]
+
+	client, err := getClient()
+	if err != nil {
+		return err
+	}
+
+	keys, err := environmentKeys(client, from)
+	if err != nil {
+		return err
+	}
+
+	if !confirm("Rename environment "+from+" to "+to, *yes) {
+		fmt.Println(Fata("Canceled"))
+		return shown(errCanceled)
+	}
+
+	fmt.Print(Teal("Renaming environment " + from + " to " + to + "... "))
+
+	if err = client.RenameEnvironment(context.Background(), from, to); err != nil {
+		fmt.Println(Fata("FAILED!"))
+		return err
+	}
+
+	fmt.Println(OK("DONE!"))
+
+	return emit(map[string]interface{}{"from": from, "to": to, "keys": keys})
+}
+
+// Given an array which may contain the following:
+//   - 0: environment to copy
+//   - 1: name of the copy
+//   - --yes: skip the confirmation prompt
+//
+// copies the environment, alongside its files, recipients and history.
+func copyEnv(args []string) error {
+	flags := flag.NewFlagSet("copy", flag.ContinueOnError)
+	yes := flags.Bool("yes", false, "do not prompt for confirmation")
+	args, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if err := requireArgs(args, 2, true, false); err != nil {
+		return err
+	}
+
+	from, to := args[0], args[1]
+
+	client, err := getClient()
+	if err != nil {
+		return err
+	}
+
+	keys, err := environmentKeys(client, from)
+	if err != nil {
+		return err
+	}
+
+	if !confirm("Copy environment "+from+" to "+to, *yes) {
+		fmt.Println(Fata("Canceled"))
+		return shown(errCanceled)
+	}
+
+	fmt.Print(Teal("Copying environment " + from + " to " + to + "... "))
+
+	if err = client.CopyEnvironment(context.Background(), from, to); err != nil {
+		fmt.Println(Fata("FAILED!"))
+		return err
+	}
+
+	fmt.Println(OK("DONE!"))
+
+	return emit(map[string]interface{}{"from": from, "to": to, "keys": keys})
+}
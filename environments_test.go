@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestManageEnvironments(t *testing.T) {
+	for name, setup := range testBackends {
+		t.Run(name, func(t *testing.T) {
+			setup(t)
+
+			writeFile(t, ".env", "KEY=value\n")
+			writeFile(t, "secrets.txt", "aws secrets")
+			captureOutput(t, "", func() error { return upload([]string{"staging"}) })
+			captureOutput(t, "", func() error { return upload([]string{"staging"}) })
+			captureOutput(t, "", func() error { return files([]string{"staging", "upload", "secrets.txt"}) })
+
+			client, err := getClient()
+			if err != nil {
+				t.Fatal(err)
+			}
+			store := client.Store()
+
+			keys := func(env string) int {
+				objects, _ := store.List(context.Background(), env+"_uploads/")
+				if _, err := store.Stat(context.Background(), "env_"+env); err != nil {
+					return len(objects)
+				}
+				return len(objects) + 1
+			}
+
+			captureOutput(t, "", func() error { return copyEnv([]string{"staging", "staging-2", "--yes"}) })
+			if keys("staging") != 2 || keys("staging-2") != 2 {
+				t.Fatalf("Environment was not copied")
+			}
+
+			// Confirming through the prompt.
+			captureOutput(t, "y\n", func() error { return renameEnv([]string{"staging-2", "staging-3"}) })
+			if keys("staging-2") != 0 || keys("staging-3") != 2 {
+				t.Fatalf("Environment was not renamed")
+			}
+
+			// Renaming must carry the history along.
+			if versions, _ := client.Versions(context.Background(), "staging-3"); len(versions) != 2 {
+				t.Errorf("History was not renamed")
+			}
+
+			captureOutput(t, "", func() error { return deleteEnv([]string{"--yes", "staging-3"}) })
+			if keys("staging-3") != 0 {
+				t.Fatalf("Environment was not deleted")
+			}
+			if objects, _ := store.List(context.Background(), "history/staging-3/"); len(objects) != 0 {
+				t.Errorf("History was not deleted")
+			}
+
+			var envs []string
+			captureOutput(t, "", func() (err error) { envs, err = list(false); return })
+			if !reflect.DeepEqual(envs, []string{"staging"}) {
+				t.Errorf("Unexpected environments: %v", envs)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"ghst.fr/matthew/copy-cat-env/copycat"
+	"github.com/minio/minio-go/v7"
+)
+
+// Returned (wrapped) when a command is invoked with the wrong arguments.
+var errUsage = errors.New("invalid usage")
+
+// Returned (wrapped) when an environment, file or profile does not exist.
+var errNotFound = copycat.ErrNotFound
+
+// Returned (wrapped) when a command would overwrite something which already
+// exists.
+var errConflict = copycat.ErrConflict
+
+// Returned (wrapped) when the user declines a confirmation prompt.
+var errCanceled = errors.New("canceled")
+
+// Exit codes the program terminates with, depending on the error returned by
+// the command (see main.go).
+const (
+	exitError    = 1
+	exitUsage    = 2
+	exitNotFound = 3
+	exitAuth     = 4
+	exitNetwork  = 5
+	exitConflict = 6
+)
+
+// Wraps an error which has already been described to the user (i.e. alongside
+// a hint on how to resolve it), so it is not printed a second time.
+type shownError struct {
+	err error
+}
+
+func (e shownError) Error() string { return e.err.Error() }
+func (e shownError) Unwrap() error { return e.err }
+
+// Marks err as already described to the user.
+func shown(err error) error {
+	return shownError{err: err}
+}
+
+// Returned by commands which should terminate the program with a given exit
+// code without reporting an error, i.e. run passing on its command's.
+type exitStatus int
+
+func (s exitStatus) Error() string { return fmt.Sprintf("exit status %d", int(s)) }
+
+// Returns a machine-readable code describing err: "not_found", "conflict",
+// "usage", "canceled", "auth", "network", or "error" for anything else.
+func errorCode(err error) string {
+	switch {
+	case errors.Is(err, errNotFound):
+		return "not_found"
+	case errors.Is(err, errConflict):
+		return "conflict"
+	case errors.Is(err, errUsage):
+		return "usage"
+	case errors.Is(err, errCanceled):
+		return "canceled"
+	}
+
+	switch minio.ToErrorResponse(err).Code {
+	case "AccessDenied", "InvalidAccessKeyId", "SignatureDoesNotMatch":
+		return "auth"
+	case "NoSuchBucket":
+		return "not_found"
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return "network"
+	}
+
+	return "error"
+}
+
+// Returns the exit code the program terminates with when a command fails with
+// err.
+func exitCode(err error) int {
+	var status exitStatus
+	if errors.As(err, &status) {
+		return int(status)
+	}
+
+	switch errorCode(err) {
+	case "usage":
+		return exitUsage
+	case "not_found":
+		return exitNotFound
+	case "auth":
+		return exitAuth
+	case "network":
+		return exitNetwork
+	case "conflict":
+		return exitConflict
+	default:
+		return exitError
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"ghst.fr/matthew/copy-cat-env/copycat"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := map[error]int{
+		fmt.Errorf("env_staging: %w", errNotFound):  exitNotFound,
+		shown(fmt.Errorf("a.txt: %w", errConflict)): exitConflict,
+		shown(fmt.Errorf("%w: bad", errUsage)):      exitUsage,
+		shown(errCanceled):                          exitError,
+		exitStatus(42):                              42,
+		errors.New("something else"):                exitError,
+	}
+
+	for err, code := range tests {
+		if got := exitCode(err); got != code {
+			t.Errorf("exitCode(%v) = %d, expected %d", err, got, code)
+		}
+	}
+}
+
+func TestCommandErrors(t *testing.T) {
+	// A missing profile is reported, rather than terminating the program.
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("COPYCAT_CONFIG_DIR", "")
+	t.Setenv("COPYCAT_PROFILE", "missing")
+
+	output, err := captureError(t, "", func() error { _, err := list(true); return err })
+	if exitCode(err) != exitNotFound || !strings.Contains(output, "copycat configure") {
+		t.Errorf("Expected a not found error, got %v\n%s", err, output)
+	}
+
+	for name, setup := range testBackends {
+		t.Run(name, func(t *testing.T) {
+			setup(t)
+
+			writeFile(t, ".env", "KEY=value\n")
+			writeFile(t, "secrets.txt", "aws secrets")
+			captureOutput(t, "", func() error { return upload([]string{"staging"}) })
+			captureOutput(t, "", func() error { return files([]string{"staging", "upload", "secrets.txt"}) })
+
+			tests := []struct {
+				name  string
+				input string
+				fn    func() error
+				code  int
+			}{
+				{"missing environment", "", func() error { return download([]string{"production"}) }, exitNotFound},
+				{"missing file environment", "", func() error { return files([]string{"production", "list"}) }, exitNotFound},
+				{"existing file", "", func() error { return files([]string{"staging", "upload", "secrets.txt"}) }, exitConflict},
+				{"existing environment", "", func() error { return copyEnv([]string{"staging", "staging", "--yes"}) }, exitConflict},
+				{"missing argument", "", func() error { return renameEnv([]string{"staging"}) }, exitUsage},
+				{"declined prompt", "n\n", func() error { return deleteEnv([]string{"staging"}) }, exitError},
+			}
+
+			for _, test := range tests {
+				if _, err := captureError(t, test.input, test.fn); exitCode(err) != test.code {
+					t.Errorf("%s: expected exit code %d, got %d (%v)", test.name, test.code, exitCode(err), err)
+				}
+			}
+
+			// The declined deletion must not have removed anything.
+			if envs, err := list(false); err != nil || len(envs) != 1 {
+				t.Errorf("Environment was deleted: %v (%v)", envs, err)
+			}
+		})
+	}
+}
+
+func TestFileErrorsKeepCode(t *testing.T) {
+	fake := newFakeS3(t, testBucket)
+	setupProfile(t, copycat.Profile{Backend: "s3", Host: fake.URL, Key: "key", Secret: "secret", Bucket: testBucket})
+
+	writeFile(t, ".env", "KEY=value\n")
+	writeFile(t, "secrets.txt", "aws secrets")
+	captureOutput(t, "", func() error { return upload([]string{"staging"}) })
+	captureOutput(t, "", func() error { return files([]string{"staging", "upload", "secrets.txt"}) })
+
+	fake.mu.Lock()
+	fake.deny = true
+	fake.mu.Unlock()
+
+	// Failing to look a file up is not the same as the file missing.
+	for name, fn := range map[string]func() error{
+		"delete": func() error { return fileDelete("staging", "secrets.txt") },
+		"move":   func() error { return fileMove("staging", "secrets.txt", "other.txt", false) },
+	} {
+		output, err := captureError(t, "", fn)
+		if exitCode(err) != exitAuth || strings.Contains(output, "File not found") {
+			t.Errorf("%s: expected an authentication error, got %v\n%s", name, err, output)
+		}
+	}
+}
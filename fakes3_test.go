@@ -0,0 +1,455 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 is an in-memory, S3-compatible server, implementing just enough of
+// the API for minio-go to create, list, upload, download and delete objects,
+// optionally keeping prior versions. Requests are never authenticated, but the
+// credentials they were signed with are recorded.
+type fakeS3 struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	buckets   map[string]map[string]fakeObject
+	versioned map[string]bool
+	versions  map[string]map[string][]fakeObject
+	nextID    int
+
+	// Rejects every request with AccessDenied, as if the credentials were
+	// revoked.
+	deny bool
+
+	// Access key, session token and Host header of the last request.
+	accessKey    string
+	sessionToken string
+	host         string
+}
+
+// A single object held by fakeS3.
+type fakeObject struct {
+	data         []byte
+	contentType  string
+	lastModified time.Time
+	versionID    string
+}
+
+func (o fakeObject) etag() string {
+	sum := md5.Sum(o.data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// Starts a new fakeS3 with the given (empty) buckets. The server is shut down
+// once the test completes.
+func newFakeS3(t *testing.T, buckets ...string) *fakeS3 {
+	f := fakeS3Buckets(buckets)
+
+	f.Server = httptest.NewServer(http.HandlerFunc(f.handle))
+	t.Cleanup(f.Close)
+
+	return f
+}
+
+// Starts a new fakeS3 serving HTTPS, with a certificate signed by its own CA
+// (see Certificate). Handshakes rejected by clients are not logged.
+func newFakeS3TLS(t *testing.T, buckets ...string) *fakeS3 {
+	f := fakeS3Buckets(buckets)
+
+	f.Server = httptest.NewUnstartedServer(http.HandlerFunc(f.handle))
+	f.Config.ErrorLog = log.New(io.Discard, "", 0)
+	f.StartTLS()
+	t.Cleanup(f.Close)
+
+	return f
+}
+
+func fakeS3Buckets(buckets []string) *fakeS3 {
+	f := &fakeS3{
+		buckets:   map[string]map[string]fakeObject{},
+		versioned: map[string]bool{},
+		versions:  map[string]map[string][]fakeObject{},
+	}
+	for _, bucket := range buckets {
+		f.buckets[bucket] = map[string]fakeObject{}
+	}
+
+	return f
+}
+
+// Returns the Host header of the last request.
+func (f *fakeS3) lastHost() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.host
+}
+
+// Returns the access key and session token of the last request.
+func (f *fakeS3) credentials() (string, string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.accessKey, f.sessionToken
+}
+
+// Returns the contents of an object, and whether it exists.
+func (f *fakeS3) object(bucket string, key string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	object, ok := f.buckets[bucket][key]
+	return object.data, ok
+}
+
+func (f *fakeS3) handle(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// i.e. "AWS4-HMAC-SHA256 Credential=<key>/<date>/<region>/s3/aws4_request, ..."
+	if _, credential, ok := strings.Cut(r.Header.Get("Authorization"), "Credential="); ok {
+		f.accessKey, _, _ = strings.Cut(credential, "/")
+	}
+	f.sessionToken = r.Header.Get("X-Amz-Security-Token")
+	f.host = r.Host
+
+	path := r.URL.Path
+	// Virtual-hosted style requests, i.e. "<bucket>.s3.example.com".
+	if bucket, _, ok := strings.Cut(r.Host, "."); ok && f.buckets[bucket] != nil {
+		path = "/" + bucket + path
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	query := r.URL.Query()
+	objects, exists := f.buckets[bucket]
+
+	if bucket == "" {
+		fakeS3Error(w, http.StatusNotImplemented, "NotImplemented", bucket, key)
+		return
+	}
+	if f.deny {
+		fakeS3Error(w, http.StatusForbidden, "AccessDenied", bucket, key)
+		return
+	}
+
+	// Bucket level operations.
+	if key == "" {
+		switch {
+		case r.Method == http.MethodGet && query.Has("location"):
+			fmt.Fprint(w, xml.Header+`<LocationConstraint xmlns="http://s3.amazonaws.com/doc/2006-03-01/">us-east-1</LocationConstraint>`)
+		case r.Method == http.MethodPut && !query.Has("versioning"):
+			if !exists {
+				f.buckets[bucket] = map[string]fakeObject{}
+			}
+		case !exists:
+			fakeS3Error(w, http.StatusNotFound, "NoSuchBucket", bucket, key)
+		case r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodPut:
+			var config struct{ Status string }
+			if err := xml.NewDecoder(r.Body).Decode(&config); err != nil {
+				fakeS3Error(w, http.StatusBadRequest, "MalformedXML", bucket, key)
+				return
+			}
+			f.versioned[bucket] = config.Status == "Enabled"
+		case r.Method == http.MethodGet && query.Has("versioning"):
+			status := ""
+			if f.versioned[bucket] {
+				status = "<Status>Enabled</Status>"
+			}
+			fmt.Fprint(w, xml.Header+`<VersioningConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/">`+status+`</VersioningConfiguration>`)
+		case r.Method == http.MethodGet && query.Has("versions"):
+			f.listVersions(w, bucket, query.Get("prefix"))
+		case r.Method == http.MethodGet:
+			f.list(w, bucket, objects, query.Get("prefix"), query.Get("delimiter"))
+		case r.Method == http.MethodDelete:
+			if len(objects) > 0 {
+				fakeS3Error(w, http.StatusConflict, "BucketNotEmpty", bucket, key)
+				return
+			}
+			delete(f.buckets, bucket)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			fakeS3Error(w, http.StatusNotImplemented, "NotImplemented", bucket, key)
+		}
+		return
+	}
+
+	if !exists {
+		fakeS3Error(w, http.StatusNotFound, "NoSuchBucket", bucket, key)
+		return
+	}
+
+	// Object level operations.
+	object, found := objects[key]
+
+	switch r.Method {
+	case http.MethodPut:
+		data, err := fakeS3Body(r)
+		if err != nil {
+			fakeS3Error(w, http.StatusBadRequest, "IncompleteBody", bucket, key)
+			return
+		}
+
+		object = fakeObject{data: data, contentType: r.Header.Get("Content-Type"), lastModified: time.Now().UTC()}
+		if f.versioned[bucket] {
+			f.nextID++
+			object.versionID = strconv.Itoa(f.nextID)
+
+			if f.versions[bucket] == nil {
+				f.versions[bucket] = map[string][]fakeObject{}
+			}
+			f.versions[bucket][key] = append(f.versions[bucket][key], object)
+			w.Header().Set("X-Amz-Version-Id", object.versionID)
+		}
+
+		objects[key] = object
+		w.Header().Set("ETag", object.etag())
+	case http.MethodGet, http.MethodHead:
+		if id := query.Get("versionId"); id != "" {
+			found = false
+			for _, version := range f.versions[bucket][key] {
+				if version.versionID == id {
+					object, found = version, true
+				}
+			}
+		}
+
+		if !found {
+			fakeS3Error(w, http.StatusNotFound, "NoSuchKey", bucket, key)
+			return
+		}
+
+		data, status := object.data, http.StatusOK
+		if start, end, ok := fakeS3Range(r.Header.Get("Range"), len(data)); ok {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+			data, status = data[start:end+1], http.StatusPartialContent
+		}
+
+		w.Header().Set("Content-Type", object.contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("ETag", object.etag())
+		w.Header().Set("Last-Modified", object.lastModified.Format(http.TimeFormat))
+		w.WriteHeader(status)
+
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	case http.MethodDelete:
+		delete(objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		fakeS3Error(w, http.StatusNotImplemented, "NotImplemented", bucket, key)
+	}
+}
+
+// Writes a ListObjects (V1 or V2) response for every object under prefix.
+func (f *fakeS3) list(w http.ResponseWriter, bucket string, objects map[string]fakeObject, prefix string, delimiter string) {
+	type contents struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int
+		StorageClass string
+	}
+	type commonPrefix struct {
+		Prefix string
+	}
+
+	result := struct {
+		XMLName        xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+		Name           string
+		Prefix         string
+		KeyCount       int
+		MaxKeys        int
+		IsTruncated    bool
+		Contents       []contents
+		CommonPrefixes []commonPrefix
+	}{Name: bucket, Prefix: prefix, MaxKeys: 1000}
+
+	var keys []string
+	for key := range objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	seen := map[string]bool{}
+	for _, key := range keys {
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				common := key[:len(prefix)+i+len(delimiter)]
+				if !seen[common] {
+					seen[common] = true
+					result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{common})
+				}
+				continue
+			}
+		}
+
+		object := objects[key]
+		result.Contents = append(result.Contents, contents{
+			Key:          key,
+			LastModified: object.lastModified.Format("2006-01-02T15:04:05.000Z"),
+			ETag:         object.etag(),
+			Size:         len(object.data),
+			StorageClass: "STANDARD",
+		})
+	}
+	result.KeyCount = len(result.Contents) + len(result.CommonPrefixes)
+
+	w.Header().Set("Content-Type", "application/xml")
+	fmt.Fprint(w, xml.Header)
+	xml.NewEncoder(w).Encode(result)
+}
+
+// Writes a ListObjectVersions response for every version under prefix, newest
+// first.
+func (f *fakeS3) listVersions(w http.ResponseWriter, bucket string, prefix string) {
+	type version struct {
+		Key          string
+		VersionId    string
+		IsLatest     bool
+		LastModified string
+		ETag         string
+		Size         int
+		StorageClass string
+	}
+
+	result := struct {
+		XMLName     xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListVersionsResult"`
+		Name        string
+		Prefix      string
+		MaxKeys     int
+		IsTruncated bool
+		Version     []version
+	}{Name: bucket, Prefix: prefix, MaxKeys: 1000}
+
+	var keys []string
+	for key := range f.versions[bucket] {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		versions := f.versions[bucket][key]
+		for i := len(versions) - 1; i >= 0; i-- {
+			result.Version = append(result.Version, version{
+				Key:          key,
+				VersionId:    versions[i].versionID,
+				IsLatest:     i == len(versions)-1,
+				LastModified: versions[i].lastModified.Format("2006-01-02T15:04:05.000Z"),
+				ETag:         versions[i].etag(),
+				Size:         len(versions[i].data),
+				StorageClass: "STANDARD",
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	fmt.Fprint(w, xml.Header)
+	xml.NewEncoder(w).Encode(result)
+}
+
+// Writes an S3 error response.
+func fakeS3Error(w http.ResponseWriter, status int, code string, bucket string, key string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprint(w, xml.Header)
+	xml.NewEncoder(w).Encode(struct {
+		XMLName    xml.Name `xml:"Error"`
+		Code       string
+		Message    string
+		BucketName string
+		Key        string
+	}{Code: code, Message: code, BucketName: bucket, Key: key})
+}
+
+// Reads an uploaded object's body, decoding "aws-chunked" streaming uploads,
+// which minio-go uses whenever it talks plain HTTP.
+func fakeS3Body(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return io.ReadAll(r.Body)
+	}
+
+	var data bytes.Buffer
+	reader := bufio.NewReader(r.Body)
+
+	for {
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		sizeHex, _, _ := strings.Cut(strings.TrimSpace(header), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return data.Bytes(), nil
+		}
+
+		if _, err := io.CopyN(&data, reader, size); err != nil {
+			return nil, err
+		}
+		if _, err := reader.Discard(2); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Parses a single "bytes=start-end" range header against an object of the
+// given length.
+func fakeS3Range(header string, length int) (int, int, bool) {
+	if !strings.HasPrefix(header, "bytes=") || length == 0 {
+		return 0, 0, false
+	}
+
+	from, to, _ := strings.Cut(strings.TrimPrefix(header, "bytes="), "-")
+	start, end := 0, length-1
+
+	if from == "" {
+		n, err := strconv.Atoi(to)
+		if err != nil {
+			return 0, 0, false
+		}
+		if n < length {
+			start = length - n
+		}
+		return start, end, true
+	}
+
+	start, err := strconv.Atoi(from)
+	if err != nil || start >= length {
+		return 0, 0, false
+	}
+	if to != "" {
+		if end, err = strconv.Atoi(to); err != nil {
+			return 0, 0, false
+		}
+		if end >= length {
+			end = length - 1
+		}
+	}
+
+	return start, end, true
+}
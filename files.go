@@ -2,177 +2,263 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
-	"log"
 	"os"
-	"strings"
-
-	"github.com/minio/minio-go/v7"
 )
 
 // Main files entrypoint. Given an array of arguments, handles calling the
 // appropriate sub-function.
-func files(args []string) {
+func files(args []string) error {
 	if len(args) < 1 {
-		fmt.Println(Warn("At least one argument is needed"))
-		help(true)
-		os.Exit(1)
+		return usage("At least one argument is needed", true)
 	}
 
 	switch args[0] {
 	case "list":
-		list(true)
+		_, err := list(true)
+		return err
 	case "help":
-		help(true)
+		return showHelp("files")
 	default:
-		validEnv(args[0], args[1:])
+		return validEnv(args[0], args[1:])
 	}
 }
 
-// Checks if an environment exists. Terminates the program if it doesn't.
-func validEnv(env string, options []string) {
-	envs := list(false)
+// Checks if an environment exists, returning a not found error if it doesn't.
+func validEnv(env string, options []string) error {
+	envs, err := list(false)
+	if err != nil {
+		return err
+	}
 
 	for _, e := range envs {
 		if e == env {
-			handleEnv(env, options)
-			return
+			return handleEnv(env, options)
 		}
 	}
 
-	log.Fatalln(Fata("Environment not found. Use ") + Teal("copycat files list") + Fata(" to view a list of valid environments."))
+	fmt.Println(Fata("Environment not found. Use ") + Teal("copycat files list") + Fata(" to view a list of valid environments."))
+	return shown(fmt.Errorf("environment %s: %w", env, errNotFound))
 }
 
 // Depending on the options provided, and an environment, call the
 // appropriate sub-function.
-func handleEnv(env string, options []string) {
+func handleEnv(env string, options []string) error {
+	flags := flag.NewFlagSet("files", flag.ContinueOnError)
+	force := flags.Bool("force", false, "overwrite existing files")
+	options, err := parseFlags(flags, options)
+	if err != nil {
+		return err
+	}
+	if err := requireArgs(options, 1, false, true); err != nil {
+		return err
+	}
+
 	switch options[0] {
 	case "list":
-		listFiles(env)
+		return listFiles(env)
 	case "upload":
-		requireArgs(options, 1, false, true)
-		fileUpload(env, options[1:])
+		if err := requireArgs(options, 2, false, true); err != nil {
+			return err
+		}
+		return fileUpload(env, options[1:], *force)
 	case "download":
-		requireArgs(options, 1, false, true)
-		fileDownload(env, options[1:])
+		if err := requireArgs(options, 2, false, true); err != nil {
+			return err
+		}
+		return fileDownload(env, options[1:], *force)
+	case "delete":
+		if err := requireArgs(options, 2, true, true); err != nil {
+			return err
+		}
+		return fileDelete(env, options[1])
+	case "move":
+		if err := requireArgs(options, 3, true, true); err != nil {
+			return err
+		}
+		return fileMove(env, options[1], options[2], *force)
+	default:
+		return usage("Not a valid option.", true)
 	}
 }
 
 // Given an environment, list all the files in that environment.
-func listFiles(env string) {
-	minioClient, bucket, err := getClient()
+func listFiles(env string) error {
+	client, err := getClient()
 	if err != nil {
-		log.Fatalln(err)
-		os.Exit(1)
+		return err
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-
-	defer cancel()
+	files, err := client.ListFiles(context.Background(), env)
+	if err != nil {
+		return err
+	}
 
-	objectCh := minioClient.ListObjects(ctx, bucket, minio.ListObjectsOptions{
-		Prefix:    env + "_uploads/",
-		Recursive: false,
-	})
+	if jsonOutput() {
+		return emit(map[string]interface{}{"environment": env, "files": files})
+	}
 
-	fmt.Println(White(env + "files:"))
+	fmt.Println(White(env + " files:"))
 
-	if len(objectCh) == 0 {
+	if len(files) == 0 {
 		fmt.Println("... " + Warn("Empty!"))
-		return
+		return nil
 	}
 
-	for object := range objectCh {
-		if object.Err != nil {
-			fmt.Println(object.Err)
-			return
-		}
-		fmt.Println(Teal(strings.Replace(object.Key, env+"_uploads/", "", 1)))
+	for _, file := range files {
+		fmt.Println(Teal(file.Name))
 	}
+
+	return nil
 }
 
 // Given an environment, and an array which may contain the following:
 //   - 0: file to upload
 //   - 1: upload name
 //
-// upload the specified file.
-func fileUpload(env string, args []string) {
-	minioClient, bucket, err := getClient()
+// upload the specified file. Refuses to overwrite an existing file unless force
+// is set.
+func fileUpload(env string, args []string, force bool) error {
+	client, err := getClient()
 	if err != nil {
-		log.Fatalln(err)
-		os.Exit(1)
+		return err
 	}
 
-	ensureBucket(minioClient, bucket)
-
 	uploadName := args[0]
 
 	if len(args) == 2 {
 		uploadName = args[1]
 	}
 
-	fmt.Print(Teal("Uploading " + args[0] + " as " + uploadName + " under environment " + env + "... "))
+	if !force {
+		if _, err = client.StatFile(context.Background(), env, uploadName); err == nil {
+			fmt.Println(Fata(uploadName+" already exists in environment "+env+". Use ") + Teal("--force") + Fata(" to overwrite it."))
+			return shown(fmt.Errorf("%s: %w", uploadName, errConflict))
+		} else if !errors.Is(err, errNotFound) {
+			return err
+		}
+	}
 
-	objectName := env + "_uploads/" + uploadName
+	fmt.Print(Teal("Uploading " + args[0] + " as " + uploadName + " under environment " + env + "... "))
 	filePath := args[0]
-	contentType := "text/plain"
 
-	// Upload the env file.
-	err = uploadFile(minioClient, objectName, filePath, contentType, bucket)
+	data, err := os.ReadFile(filePath)
+	if err == nil {
+		err = client.PutFile(context.Background(), env, uploadName, data)
+	}
 	if err != nil {
 		fmt.Println(Fata("FAILED!"))
-		log.Fatalln(err)
+		return err
 	}
 
 	fmt.Println(OK("DONE!"))
+
+	return emitTransfer(client, env, filePath, env+"_uploads/"+uploadName)
 }
 
 // Given an environment, and an array which may contain the following:
 //   - 0: file to download
 //   - 1: download name
 //
-// download the specified file.
-func fileDownload(env string, args []string) {
-	minioClient, bucket, err := getClient()
+// download the specified file. Refuses to overwrite an existing local file
+// unless force is set.
+func fileDownload(env string, args []string, force bool) error {
+	client, err := getClient()
 	if err != nil {
-		log.Fatalln(err)
-		os.Exit(1)
+		return err
 	}
 
-	ensureBucket(minioClient, bucket)
-
 	dlName := args[0]
 
 	if len(args) == 2 {
 		dlName = args[1]
 	}
 
+	if !force {
+		if _, err = os.Stat("./" + dlName); err == nil {
+			fmt.Println(Fata(dlName+" already exists. Use ") + Teal("--force") + Fata(" to overwrite it."))
+			return shown(fmt.Errorf("%s: %w", dlName, errConflict))
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
 	fmt.Print(Teal("Downloading " + args[0] + " from environment " + env + " as " + dlName + "... "))
 
-	object, err := minioClient.GetObject(context.Background(), bucket, env+"_uploads/"+args[0], minio.GetObjectOptions{})
-	_, exists := object.Stat()
-	if err != nil || exists != nil {
+	data, err := client.GetFile(context.Background(), env, args[0])
+	if err == nil {
+		err = os.WriteFile("./"+dlName, data, 0644)
+	}
+	if err != nil {
 		fmt.Println(Fata("FAILED!"))
-		if err != nil {
-			fmt.Println(err)
-		} else {
-			fmt.Println(exists)
-		}
-		return
+		return err
 	}
 
-	localFile, err := os.Create("./" + dlName)
+	fmt.Println(OK("DONE!"))
+
+	return emitTransfer(client, env, "./"+dlName, env+"_uploads/"+args[0])
+}
+
+// Given an environment and a file name, delete the specified file.
+func fileDelete(env string, name string) error {
+	client, err := getClient()
 	if err != nil {
+		return err
+	}
+
+	if _, err = client.StatFile(context.Background(), env, name); errors.Is(err, errNotFound) {
+		fmt.Println(Fata("File not found: "), err)
+		return shown(err)
+	} else if err != nil {
+		return err
+	}
+
+	fmt.Print(Teal("Deleting " + name + " from environment " + env + "... "))
+
+	if err = client.DeleteFile(context.Background(), env, name); err != nil {
 		fmt.Println(Fata("FAILED!"))
-		fmt.Println(err)
-		return
+		return err
+	}
+
+	fmt.Println(OK("DONE!"))
+
+	return emit(map[string]string{"environment": env, "deleted": name})
+}
+
+// Given an environment, a file name and a new name, move the specified file.
+// Refuses to overwrite an existing file unless force is set.
+func fileMove(env string, name string, newName string, force bool) error {
+	client, err := getClient()
+	if err != nil {
+		return err
+	}
+
+	if _, err = client.StatFile(context.Background(), env, name); errors.Is(err, errNotFound) {
+		fmt.Println(Fata("File not found: "), err)
+		return shown(err)
+	} else if err != nil {
+		return err
+	}
+
+	if !force {
+		if _, err = client.StatFile(context.Background(), env, newName); err == nil {
+			fmt.Println(Fata(newName+" already exists in environment "+env+". Use ") + Teal("--force") + Fata(" to overwrite it."))
+			return shown(fmt.Errorf("%s: %w", newName, errConflict))
+		} else if !errors.Is(err, errNotFound) {
+			return err
+		}
 	}
-	if _, err = io.Copy(localFile, object); err != nil {
+
+	fmt.Print(Teal("Moving " + name + " to " + newName + " in environment " + env + "... "))
+
+	if err = client.MoveFile(context.Background(), env, name, newName); err != nil {
 		fmt.Println(Fata("FAILED!"))
-		fmt.Println(err)
-		return
+		return err
 	}
 
 	fmt.Println(OK("DONE!"))
+
+	return emit(map[string]string{"environment": env, "from": name, "to": newName})
 }
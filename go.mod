@@ -3,12 +3,19 @@ module ghst.fr/matthew/copy-cat-env
 go 1.18
 
 require (
+	github.com/dustin/go-humanize v1.0.0
 	github.com/joho/godotenv v1.4.0
 	github.com/minio/minio-go/v7 v7.0.45
+	github.com/zalando/go-keyring v0.2.1
+	golang.org/x/crypto v0.4.0
+	golang.org/x/term v0.5.0
+	gopkg.in/yaml.v3 v3.0.0
 )
 
 require (
-	github.com/dustin/go-humanize v1.0.0 // indirect
+	github.com/alessio/shellescape v1.4.1 // indirect
+	github.com/danieljoos/wincred v1.1.0 // indirect
+	github.com/godbus/dbus/v5 v5.0.6 // indirect
 	github.com/google/uuid v1.3.0 // indirect
 	github.com/json-iterator/go v1.1.12 // indirect
 	github.com/klauspost/compress v1.15.13 // indirect
@@ -19,10 +26,8 @@ require (
 	github.com/modern-go/reflect2 v1.0.2 // indirect
 	github.com/rs/xid v1.4.0 // indirect
 	github.com/sirupsen/logrus v1.9.0 // indirect
-	golang.org/x/crypto v0.4.0 // indirect
 	golang.org/x/net v0.7.0 // indirect
 	golang.org/x/sys v0.5.0 // indirect
 	golang.org/x/text v0.7.0 // indirect
 	gopkg.in/ini.v1 v1.67.0 // indirect
-	gopkg.in/yaml.v3 v3.0.0 // indirect
 )
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"ghst.fr/matthew/copy-cat-env/copycat"
+	"github.com/dustin/go-humanize"
+)
+
+// Lists every version of an environment, alongside its timestamp and size.
+func history(env string) error {
+	client, err := getClient()
+	if err != nil {
+		return err
+	}
+
+	versions, err := client.Versions(context.Background(), env)
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput() {
+		if versions == nil {
+			versions = []copycat.ObjectVersion{}
+		}
+		return emit(map[string]interface{}{"environment": env, "versions": versions})
+	}
+
+	fmt.Println(White(env + " history:"))
+
+	if len(versions) == 0 {
+		fmt.Println("... " + Warn("Empty!"))
+		return nil
+	}
+
+	for _, version := range versions {
+		line := fmt.Sprintf("%s  %s  %s", Teal(version.VersionID), version.LastModified.Local().Format(time.RFC1123), humanize.Bytes(uint64(version.Size)))
+		if version.IsLatest {
+			line += " " + OK("(current)")
+		}
+		fmt.Println(line)
+	}
+
+	return nil
+}
+
+// Restores a prior version of an environment. The version being replaced is
+// kept in the environment's history.
+func rollback(env string, id string) error {
+	client, err := getClient()
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(Teal("Restoring version " + id + " of " + env + "... "))
+
+	if err = client.Rollback(context.Background(), env, id); err != nil {
+		fmt.Println(Fata("FAILED!"))
+		return err
+	}
+
+	fmt.Println(OK("DONE!"))
+
+	return emit(map[string]string{"environment": env, "version": id})
+}
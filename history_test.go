@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"ghst.fr/matthew/copy-cat-env/copycat"
+)
+
+func TestHistory(t *testing.T) {
+	backends := map[string]func(t *testing.T) string{
+		"s3-versioned": func(t *testing.T) string {
+			fake := newFakeS3(t, testBucket)
+			fake.versioned[testBucket] = true
+			return setupProfile(t, copycat.Profile{
+				Backend: "s3",
+				Host:    fake.URL,
+				Key:     "key",
+				Secret:  "secret",
+				Bucket:  testBucket,
+			})
+		},
+	}
+	for name, setup := range testBackends {
+		backends[name] = setup
+	}
+
+	for name, setup := range backends {
+		t.Run(name, func(t *testing.T) {
+			setup(t)
+
+			for _, env := range []string{"VERSION=1\n", "VERSION=2\n", "VERSION=3\n"} {
+				writeFile(t, ".env", env)
+				captureOutput(t, "", func() error { return upload([]string{"staging"}) })
+
+				// Version IDs may be derived from modification times.
+				time.Sleep(5 * time.Millisecond)
+			}
+
+			client, err := getClient()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			versions, err := client.Versions(context.Background(), "staging")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(versions) != 3 || !versions[0].IsLatest || versions[1].IsLatest {
+				t.Fatalf("Unexpected versions: %+v", versions)
+			}
+
+			output := captureOutput(t, "", func() error { return history("staging") })
+			for _, version := range versions {
+				if !strings.Contains(output, version.VersionID) {
+					t.Errorf("Version %s not listed:\n%s", version.VersionID, output)
+				}
+			}
+
+			// Restore the very first version.
+			captureOutput(t, "", func() error { return rollback("staging", versions[2].VersionID) })
+			captureOutput(t, "", func() error { return download([]string{"staging"}) })
+
+			if got := readFile(t, ".env"); got != "VERSION=1\n" {
+				t.Errorf("Rolled back .env does not match: %q", got)
+			}
+
+			// The version replaced by the rollback must not be lost.
+			if versions, _ = client.Versions(context.Background(), "staging"); len(versions) != 4 {
+				t.Errorf("Expected 4 versions after rollback, got %d", len(versions))
+			}
+		})
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"ghst.fr/matthew/copy-cat-env/copycat"
+)
+
+// Main keys entrypoint. Given an array of arguments, handles calling the
+// appropriate sub-function.
+func keys(args []string) error {
+	if len(args) < 1 {
+		return keysUsage("At least one argument is needed")
+	}
+
+	switch args[0] {
+	case "generate":
+		return generateKeys()
+	case "list":
+		if len(args) != 2 {
+			return keysUsage("Expected 2 argument(s), got " + fmt.Sprint(len(args)))
+		}
+		return listRecipients(args[1])
+	case "add":
+		if len(args) != 3 {
+			return keysUsage("Expected 3 argument(s), got " + fmt.Sprint(len(args)))
+		}
+		return addRecipient(args[1], args[2])
+	case "remove":
+		if len(args) != 3 {
+			return keysUsage("Expected 3 argument(s), got " + fmt.Sprint(len(args)))
+		}
+		return removeRecipient(args[1], args[2])
+	case "trust":
+		return trustRecipients(args[1:])
+	case "help":
+		return showHelp("keys")
+	default:
+		return keysUsage("Not a valid option.")
+	}
+}
+
+// Prints a usage error alongside the keys help message, and returns it.
+func keysUsage(message string) error {
+	return topicUsage("keys", message)
+}
+
+// Creates a new identity (X25519 key pair), and prints its public key. The
+// identity is never overwritten; if it already exists its public key is
+// printed instead.
+func generateKeys() error {
+	name, profile, err := loadProfile()
+	if err != nil {
+		return err
+	}
+
+	opts, err := copycat.ProfileOptions(name, profile)
+	if err != nil {
+		return err
+	}
+
+	path := opts.IdentityFile
+
+	identity, err := copycat.ReadIdentity(path)
+	if errors.Is(err, os.ErrNotExist) {
+		fmt.Print(Teal("Generating new identity in " + path + "... "))
+
+		identity, err = copycat.GenerateIdentity()
+		if err == nil {
+			err = os.MkdirAll(filepath.Dir(path), 0700)
+		}
+		if err == nil {
+			err = copycat.WriteIdentity(path, identity)
+		}
+		if err != nil {
+			fmt.Println(Fata("FAILED!"))
+			return err
+		}
+
+		fmt.Println(OK("DONE!"))
+	} else if err != nil {
+		return err
+	} else {
+		fmt.Println(Warn("Identity already exists: ") + path)
+	}
+
+	public, err := copycat.PublicKey(identity)
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput() {
+		return emit(map[string]string{"identity_file": path, "public_key": base64.StdEncoding.EncodeToString(public)})
+	}
+
+	fmt.Println(White("Public key:"))
+	fmt.Println(base64.StdEncoding.EncodeToString(public))
+
+	return nil
+}
+
+// Lists the public keys an environment is encrypted to.
+func listRecipients(env string) error {
+	client, err := getClient()
+	if err != nil {
+		return err
+	}
+
+	recipients, err := client.Recipients(context.Background(), env)
+	if err != nil {
+		return err
+	}
+
+	untrusted, err := client.UntrustedRecipients(context.Background(), env)
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput() {
+		return emitRecipients(env, recipients, untrusted)
+	}
+
+	fmt.Println(White(env + " recipients:"))
+
+	if len(recipients) == 0 {
+		fmt.Println("... " + Warn("Empty!"))
+		return nil
+	}
+
+	for _, recipient := range recipients {
+		if copycat.ContainsKey(untrusted, recipient) {
+			fmt.Println(Fata(base64.StdEncoding.EncodeToString(recipient)) + Warn(" (untrusted)"))
+		} else {
+			fmt.Println(Teal(base64.StdEncoding.EncodeToString(recipient)))
+		}
+	}
+
+	if len(untrusted) > 0 {
+		fmt.Println(Warn("Untrusted recipients were not added from this machine. Run ") + Info("copycat keys trust "+env) + Warn(" once you have checked them with their owners."))
+	}
+
+	return nil
+}
+
+// Writes the public keys an environment is encrypted to as a JSON document,
+// alongside those which are not trusted on this machine.
+func emitRecipients(env string, recipients [][]byte, untrusted [][]byte) error {
+	encode := func(keys [][]byte) []string {
+		encoded := []string{}
+		for _, key := range keys {
+			encoded = append(encoded, base64.StdEncoding.EncodeToString(key))
+		}
+		return encoded
+	}
+
+	return emit(map[string]interface{}{"environment": env, "recipients": encode(recipients), "untrusted": encode(untrusted)})
+}
+
+// Given an array which may contain the following:
+//   - 0: environment
+//   - --yes: do not prompt for confirmation
+//
+// trusts every recipient the environment lists, i.e. once a key added by a
+// teammate was checked with them. Until then, uploads to the environment are
+// refused, as anyone with access to the bucket can add their own key.
+func trustRecipients(args []string) error {
+	flags := flag.NewFlagSet("keys trust", flag.ContinueOnError)
+	yes := flags.Bool("yes", false, "do not prompt for confirmation")
+	args, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return keysUsage("Expected 2 argument(s), got " + fmt.Sprint(len(args)+1))
+	}
+	env := args[0]
+
+	client, err := getClient()
+	if err != nil {
+		return err
+	}
+
+	untrusted, err := client.UntrustedRecipients(context.Background(), env)
+	if err != nil {
+		return err
+	}
+
+	if len(untrusted) == 0 {
+		fmt.Println(OK("Every recipient of " + env + " is already trusted."))
+		return emitRecipients(env, nil, nil)
+	}
+
+	fmt.Println(White("Untrusted recipients of " + env + ":"))
+	for _, recipient := range untrusted {
+		fmt.Println(Fata(base64.StdEncoding.EncodeToString(recipient)))
+	}
+
+	if !confirm("Trust these keys to decrypt "+env, *yes) {
+		fmt.Println(Fata("Aborting!"))
+		return shown(errCanceled)
+	}
+
+	if err = client.TrustRecipients(context.Background(), env); err != nil {
+		return err
+	}
+
+	fmt.Println(OK("Trusted!"))
+
+	recipients, err := client.Recipients(context.Background(), env)
+	if err != nil {
+		return err
+	}
+
+	return emitRecipients(env, recipients, nil)
+}
+
+// Adds a public key to an environment's recipients, and re-encrypts the
+// environment.
+func addRecipient(env string, key string) error {
+	public, err := copycat.ParsePublicKey(key)
+	if err != nil {
+		return err
+	}
+
+	return changeRecipients(env, public, func(recipients [][]byte) ([][]byte, error) {
+		for _, recipient := range recipients {
+			if bytes.Equal(recipient, public) {
+				fmt.Println(Warn("Already a recipient, re-encrypting anyway."))
+				return recipients, nil
+			}
+		}
+
+		return append(recipients, public), nil
+	})
+}
+
+// Removes a public key from an environment's recipients, and re-encrypts the
+// environment, so the key can no longer decrypt future versions.
+func removeRecipient(env string, key string) error {
+	public, err := copycat.ParsePublicKey(key)
+	if err != nil {
+		return err
+	}
+
+	return changeRecipients(env, public, func(recipients [][]byte) ([][]byte, error) {
+		var remaining [][]byte
+		for _, recipient := range recipients {
+			if !bytes.Equal(recipient, public) {
+				remaining = append(remaining, recipient)
+			}
+		}
+
+		if len(remaining) == len(recipients) {
+			fmt.Println(Fata("Not a recipient of ") + env)
+			return nil, shown(fmt.Errorf("recipient of %s: %w", env, errNotFound))
+		}
+		if len(remaining) == 0 {
+			fmt.Println(Fata("Refusing to remove the last recipient of ") + env)
+			return nil, shown(fmt.Errorf("%w: %s must keep at least one recipient", errConflict, env))
+		}
+
+		return remaining, nil
+	})
+}
+
+// Applies change to an environment's recipients, then re-encrypts the
+// environment and all of its files to the new recipients. Nothing is changed if
+// change returns an error, or if the new recipients hold untrusted keys other
+// than the one given by the user (key).
+func changeRecipients(env string, key []byte, change func([][]byte) ([][]byte, error)) error {
+	client, err := getClient()
+	if err != nil {
+		return err
+	}
+
+	if _, err = client.StatEnvironment(context.Background(), env); err != nil {
+		fmt.Println(Fata("Environment not found: "), err)
+		return shown(err)
+	}
+
+	current, err := client.Encryption(context.Background(), env)
+	if err != nil {
+		return err
+	}
+
+	updated, err := change(current.Recipients)
+	if err != nil {
+		return err
+	}
+
+	// Keys added outside of this machine are never carried over unreviewed,
+	// as they may have been added by someone with access to the bucket.
+	untrusted, err := client.UntrustedRecipients(context.Background(), env)
+	if err != nil {
+		return err
+	}
+	for _, recipient := range untrusted {
+		if copycat.ContainsKey(updated, recipient) && !bytes.Equal(recipient, key) {
+			fmt.Println(Fata(env+" lists recipients which are not trusted on this machine. Run ") + Teal("copycat keys list "+env) + Fata(" to review them."))
+			return shown(fmt.Errorf("%s: %w", env, copycat.ErrUntrustedRecipients))
+		}
+	}
+
+	warnNotRecipient(current.IdentityFile, updated)
+
+	fmt.Print(Teal("Updating recipients of " + env + " and re-encrypting its files... "))
+
+	if err = client.SetRecipients(context.Background(), env, updated); err != nil {
+		fmt.Println(Fata("FAILED!"))
+		return err
+	}
+
+	fmt.Println(OK("DONE!"))
+
+	return emitRecipients(env, updated, nil)
+}
+
+// Warns the user if their own identity is not among the recipients, as they
+// would no longer be able to decrypt the environment.
+func warnNotRecipient(identityFile string, recipients [][]byte) {
+	identity, err := copycat.ReadIdentity(identityFile)
+	if err != nil {
+		fmt.Println(Warn("No identity found, you will not be able to decrypt this environment."))
+		return
+	}
+
+	public, err := copycat.PublicKey(identity)
+	if err != nil {
+		return
+	}
+
+	for _, recipient := range recipients {
+		if bytes.Equal(recipient, public) {
+			return
+		}
+	}
+
+	fmt.Println(Warn("Your own public key is not a recipient, you will not be able to decrypt this environment."))
+}
@@ -7,25 +7,120 @@ Any S3-compliant system (i.e., Amazon S3, MinIO, CloudFlare R2) can be used to
 store the files, provided you can create an access key and secret for a
 specified bucket.
 
-CopyCat now also supports profiles. By default, the "default" profile is used.
-Profiles allow for multiple configurations to be created, and later referenced.
+CopyCat now also supports profiles. The profile used is given by -profile,
+otherwise COPYCAT_PROFILE, otherwise the project manifest (see below),
+otherwise the one chosen with "copycat profile use" ("default" unless
+changed). Profiles allow for multiple configurations to be
+created, and later referenced. They are all stored in "config.yml" (profiles
+written by older versions are migrated into it), inside -config-dir (or
+COPYCAT_CONFIG_DIR), otherwise in "copycat" inside XDG_CONFIG_HOME or the
+user's config directory (~/.config/copycat on Linux).
+It is only readable by the current user, and refused if it holds a secret
+which other users can read. Each profile can set an environment used when
+none is given, and its own encryption settings. Rather than a static key and
+secret, profiles can resolve short-lived credentials (credentials) from the AWS_*
+environment variables (env), an AWS shared credentials file, including
+credential_process (file), the EC2/ECS metadata endpoint (iam), or each of
+those in turn (chain). Profiles can also set how buckets are addressed
+(bucket_lookup), a private CA bundle (ca_bundle), a proxy (proxy) and
+timeouts (connect_timeout, request_timeout), i.e. for an on-prem MinIO.
+
+Files can optionally be encrypted client-side before being uploaded, either
+with a passphrase (-encrypt, read from COPYCAT_PASSPHRASE or prompted for) or
+with a key file (-key-file, or encryption.key_file in the profile). Encrypted
+files are transparently decrypted when downloaded.
+
+Uploading an environment never loses the version it replaces: buckets with
+versioning enabled keep prior versions natively, otherwise they are archived
+under the "history/" prefix.
+
+A project can check in a manifest (".copycat.yml", found in the working
+directory or any of its parents) naming its profile, default environment, the
+path of its .env file, and the files of the environment with their local
+paths. "copycat pull" then restores all of them at once, and "copycat push"
+uploads them.
+
+Environments can also be encrypted to a set of X25519 public keys
+(recipients), so every teammate decrypts with their own private key, created
+with "copycat keys generate". Changing the recipients re-encrypts the
+environment and all of its files.
 
 Usage:
 
-	copycat [-profile <name>] <command>
+	copycat [-profile <name>] [-config-dir <dir>] [-encrypt] [-key-file <path>] [-output json] <command>
+
+With -output json, every command writes a single JSON document to stdout (its
+result, or an "error" object holding a code and message) and nothing else;
+progress messages and prompts are written to stderr instead. Usage errors,
+including unknown flags, are reported as errors, and help as a "help" document.
+The run command is the exception: stdout belongs to the command it runs, so it
+writes no document of its own once the command started.
 
 The commands are:
 
 	help
 		Prints out the help message
+	configure [--backend s3|fs] [--host <url>] [--key <key>] [--secret-stdin]
+	          [--bucket <name>] [--region <region>] [--path <dir>]
+	          [--credentials <source>] [--credentials-profile <name>]
+	          [--bucket-lookup dns|path|auto] [--ca-bundle <pem>]
+	          [--insecure-skip-verify] [--proxy <url>]
+	          [--connect-timeout <duration>] [--request-timeout <duration>]
+	          [--keyring] [--yes]
+		Creates the profile, prompting for any connection detail not given
+		as a flag or COPYCAT_* environment variable (COPYCAT_HOST,
+		COPYCAT_KEY, COPYCAT_SECRET, ...). With --yes or --secret-stdin,
+		nothing is prompted for, and --yes overwrites an existing profile.
+		With --keyring, the secret is stored in the OS keyring rather than
+		the profile
 	list
 		Lists the environments which have been uploaded
-	download <environment>
-		Downloads a given .env file corresponding to the environment name
-	upload <environment>
-		Uploads a given .env file
+	download [environment] [--file <path>|-]
+		Downloads a given .env file corresponding to the environment name,
+		to ./.env or the given file ("-" for stdout)
+	upload [environment] [--file <path>|-]
+		Uploads ./.env or the given file ("-" for stdin). Without an
+		environment, the manifest's or profile's environment is used (for
+		download, history, pull and push too)
+	pull [environment] [--force]
+		Downloads the .env file and every file listed in .copycat.yml,
+		refusing to overwrite existing local files unless --force is given
+	push [environment] [--force]
+		Uploads the .env file and every file listed in .copycat.yml,
+		refusing to overwrite files of the environment unless --force is
+		given
+	delete <environment> [--yes]
+		Deletes an environment, alongside its files and history
+	rename <environment> <new name> [--yes]
+		Renames an environment, alongside its files and history
+	copy <environment> <new name> [--yes]
+		Copies an environment, alongside its files and history
+	diff <environment> [other] [--show-values]
+		Lists the keys added, removed or changed between the local .env and
+		an environment (or between two environments)
+	run <environment> -- <cmd> [args...]
+		Runs a command with the environment's variables injected, without
+		writing a .env file to disk
+	history [environment]
+		Lists the prior versions of an environment
+	rollback <environment> <version>
+		Restores a prior version of an environment
 	files <sub-command>
 		See below.
+	keys <sub-command>
+		Manages the public keys an environment is encrypted to (generate,
+		list, add, remove, trust). Uploads are refused while an environment
+		lists keys which were not added or trusted from this machine
+	profile <sub-command>
+		Manages profiles (list, show [name], use <name>, copy, rename,
+		delete). Secrets are masked when shown
+	update [--channel stable|beta] [--to <version>] [--check] [--yes]
+		Installs the latest release of a channel (or a given version), once
+		the signed release index and its SHA-256 sum are verified. The
+		previous binary is restored if the new one fails to run. --check
+		only reports whether a newer release is available. Other commands
+		check for a newer stable release once a day, and mention it on
+		stderr, unless COPYCAT_NO_UPDATE_CHECK is set
 
 As of now, copycat expects the file ".env" to exist, and that is the file it
 will automatically upload. Once an environment is created
@@ -36,82 +131,196 @@ management is handled via the following sub-commands:
 		Prints out the files help message
 	<environment> list
 		Lists the files available in a given environment
-	<environment> upload <file name> [upload name]
+	<environment> upload <file name> [upload name] [--force]
 		Uploads the specified file under the given environment
-	<environment> download <file name> [download name]
+	<environment> download <file name> [download name] [--force]
 		Downloads the specified file, allowing for it's name to be
 		overwritten
+	<environment> delete <file name>
+		Deletes the specified file
+	<environment> move <file name> <new name> [--force]
+		Renames the specified file
+
+Existing files (remote or local) are never overwritten, unless --force is
+given.
+
+Exit codes:
+
+	0	Success
+	1	Any other error (including a declined confirmation prompt)
+	2	Invalid usage, i.e. a missing argument or unknown command
+	3	Not found: the profile, environment, file or bucket does not exist
+	4	Authentication failed: the key or secret was rejected
+	5	Network error: the storage could not be reached
+	6	Conflict: the command would overwrite something which already exists
+
+The run command exits with the code of the command it ran instead.
 */
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 )
 
 const version string = "v1.5.0"
 
-var VersionHost string
-var VersionLog string
+// Root URL releases are published under (see update), set at build time.
+var ReleaseHost string
 
 // Main function routine, serves as main entry point.
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Println(Warn("At least one argument is needed"))
-		help(false)
-		os.Exit(1)
+	profilePtr := flag.String("profile", "", "profile to be used (COPYCAT_PROFILE, defaults to the one chosen with \"copycat profile use\")")
+	encryptPtr := flag.Bool("encrypt", false, "encrypt uploads with a passphrase")
+	keyFilePtr := flag.String("key-file", "", "key file used to encrypt and decrypt")
+	outputPtr := flag.String("output", "text", "output format, text or json")
+	configDirPtr := flag.String("config-dir", "", "directory profiles are stored in (COPYCAT_CONFIG_DIR)")
+
+	// Invalid flags are reported as usage errors (once the output format is
+	// known), like those of every command.
+	flag.CommandLine.Init("copycat", flag.ContinueOnError)
+	flag.CommandLine.SetOutput(io.Discard)
+	flagErr := flag.CommandLine.Parse(os.Args[1:])
+
+	// The active profile itself is only resolved by commands which need one
+	// (see activeProfile), so commands such as version never read the config.
+	if *configDirPtr != "" {
+		os.Setenv("COPYCAT_CONFIG_DIR", *configDirPtr)
+	}
+	if *profilePtr != "" {
+		os.Setenv("COPYCAT_PROFILE", *profilePtr)
+	}
+
+	// Encryption settings, these take precedence over the profile's.
+	if *encryptPtr {
+		os.Setenv("COPYCAT_ENCRYPT", "1")
+	}
+	if *keyFilePtr != "" {
+		os.Setenv("COPYCAT_KEY_FILE", *keyFilePtr)
+	}
+
+	// In JSON mode, only the result document is written to stdout; progress
+	// messages and prompts go to stderr instead.
+	switch *outputPtr {
+	case "text":
+	case "json":
+		os.Setenv("COPYCAT_OUTPUT", "json")
+		os.Stdout = os.Stderr
+	default:
+		fail(usage("Unknown output format: "+*outputPtr, false))
 	}
 
-	// Get default profile, unless profile is explicitly defined.
-	profilePtr := flag.String("profile", "default", "profile to be used")
-	flag.Parse()
-	os.Setenv("COPYCAT_PROFILE", *profilePtr)
+	if errors.Is(flagErr, flag.ErrHelp) {
+		if err := showHelp(""); err != nil {
+			fail(err)
+		}
+		return
+	} else if flagErr != nil {
+		fail(usage(flagErr.Error(), false))
+	}
 
 	// Load environment variables
-	os.Setenv("VERSION_LOG", VersionLog)
-	os.Setenv("VERSION_HOST", VersionHost)
+	os.Setenv("RELEASE_HOST", ReleaseHost)
+
+	// Checked while the command runs, and reported once it is done.
+	notify := checkForUpdate(flag.Args())
+
+	err := dispatch(flag.Args())
+	notify(os.Stderr)
 
-	args := flag.Args()
+	if err != nil {
+		fail(err)
+	}
+}
+
+// Runs the command given by args, returning the error it failed with.
+func dispatch(args []string) error {
+	if len(args) == 0 {
+		return usage("At least one argument is needed", false)
+	}
 
 	// Case on passed arguments
 	switch args[0] {
 	case "configure":
-		configure()
+		return configure(args[1:])
 
 	case "list":
-		list(true)
+		_, err := list(true)
+		return err
 
 	case "download":
-		requireArgs(args, 2, true, false)
-		name := args[1]
-		download(name)
+		return download(args[1:])
 
 	case "upload":
-		requireArgs(args, 2, true, false)
-		name := args[1]
-		upload(name)
+		return upload(args[1:])
+
+	case "pull":
+		return pull(args[1:])
+
+	case "push":
+		return push(args[1:])
+
+	case "delete":
+		return deleteEnv(args[1:])
+
+	case "rename":
+		return renameEnv(args[1:])
+
+	case "copy":
+		return copyEnv(args[1:])
+
+	case "diff":
+		return diff(args[1:])
+
+	case "run":
+		return run(args[1:])
+
+	case "history":
+		env, err := environmentArg(args[1:])
+		if err != nil {
+			return err
+		}
+		return history(env)
+
+	case "rollback":
+		if err := requireArgs(args, 3, true, false); err != nil {
+			return err
+		}
+		return rollback(args[1], args[2])
 
 	case "files":
-		files(args[1:])
+		return files(args[1:])
+
+	case "keys":
+		return keys(args[1:])
+
+	case "profile":
+		return profile(args[1:])
 
 	case "help":
-		help(false)
+		return showHelp("")
 
 	case "version":
+		if jsonOutput() {
+			return emit(map[string]string{"version": version})
+		}
 		fmt.Println(OK(version))
+		return nil
 
 	case "version-clean":
-		fmt.Println(version)
+		fmt.Fprintln(results, version)
+		return nil
 
 	case "update":
-		update()
+		return update(args[1:])
 
 	case "reset":
-		reset()
+		return reset()
 
 	default:
-		fmt.Println(Warn("Not a valid option."))
-		help(false)
+		return usage("Not a valid option.", false)
 	}
 }
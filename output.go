@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// Where command results are written. In JSON mode, os.Stdout is pointed at
+// stderr instead (see main), so progress messages and prompts never end up
+// mixed into the document.
+var results = os.Stdout
+
+// Returns whether results should be written as JSON (-output json).
+func jsonOutput() bool {
+	return os.Getenv("COPYCAT_OUTPUT") == "json"
+}
+
+// Writes a command's result as a single JSON document. Does nothing unless
+// JSON output is enabled, as commands print their results as they go
+// otherwise.
+func emit(doc interface{}) error {
+	if !jsonOutput() {
+		return nil
+	}
+
+	encoder := json.NewEncoder(results)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+
+	return encoder.Encode(doc)
+}
+
+// Describes an error in JSON output.
+type errorInfo struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Reports the error a command failed with, and terminates the program with the
+// matching exit code. In JSON mode, the error is written to stdout as a
+// document of its own, alongside its code.
+func fail(err error) {
+	var status exitStatus
+	if errors.As(err, &status) {
+		os.Exit(int(status))
+	}
+
+	var described shownError
+	if jsonOutput() {
+		emit(map[string]errorInfo{"error": {Code: errorCode(err), Message: err.Error()}})
+	} else if !errors.As(err, &described) {
+		log.Println(err)
+	}
+
+	os.Exit(exitCode(err))
+}
+
+// Prints a usage error alongside the relevant help message (to stderr in JSON
+// mode), and returns it.
+func usage(message string, files bool) error {
+	if files {
+		return topicUsage("files", message)
+	}
+
+	return topicUsage("", message)
+}
+
+// Prints a usage error alongside the help message of a group of commands (see
+// help), and returns it.
+func topicUsage(topic string, message string) error {
+	fmt.Println(Warn(message))
+	help(topic)
+
+	return shown(fmt.Errorf("%w: %s", errUsage, message))
+}
+
+// Returns the help topic of the command a flag set belongs to, i.e. "keys" for
+// "keys trust".
+func helpTopic(command string) string {
+	switch topic, _, _ := strings.Cut(command, " "); topic {
+	case "files", "keys", "profile":
+		return topic
+	}
+
+	return ""
+}
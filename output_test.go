@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"ghst.fr/matthew/copy-cat-env/copycat"
+)
+
+// Runs fn in JSON mode, returning everything written as results.
+func captureResults(t *testing.T, fn func() error) string {
+	t.Setenv("COPYCAT_OUTPUT", "json")
+
+	previous := results
+	defer func() { results = previous }()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		output <- string(data)
+	}()
+
+	results = w
+	captureOutput(t, "", fn)
+	w.Close()
+
+	return <-output
+}
+
+func TestJSONOutput(t *testing.T) {
+	for name, setup := range testBackends {
+		t.Run(name, func(t *testing.T) {
+			setup(t)
+
+			writeFile(t, ".env", "KEY=value\n")
+
+			var uploaded transferInfo
+			if err := json.Unmarshal([]byte(captureResults(t, func() error { return upload([]string{"staging"}) })), &uploaded); err != nil {
+				t.Fatal(err)
+			}
+			if uploaded.Environment != "staging" || uploaded.Object.Key != "env_staging" || uploaded.Object.Size != 10 {
+				t.Errorf("Unexpected upload result: %+v", uploaded)
+			}
+
+			var listed struct {
+				Environments []copycat.Environment `json:"environments"`
+			}
+			if err := json.Unmarshal([]byte(captureResults(t, func() error { _, err := list(true); return err })), &listed); err != nil {
+				t.Fatal(err)
+			}
+			if len(listed.Environments) != 1 || listed.Environments[0].Name != "staging" || listed.Environments[0].LastModified.IsZero() {
+				t.Errorf("Unexpected list result: %+v", listed)
+			}
+
+			// Empty listings are arrays rather than null.
+			output := captureResults(t, func() error { return listFiles("staging") })
+			var files struct {
+				Files []copycat.File `json:"files"`
+			}
+			if err := json.Unmarshal([]byte(output), &files); err != nil || files.Files == nil {
+				t.Errorf("Unexpected files result: %s", output)
+			}
+		})
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	tests := map[error]string{
+		fmt.Errorf("env_staging: %w", errNotFound): "not_found",
+		fmt.Errorf("a.txt: %w", errConflict):       "conflict",
+		fmt.Errorf("%w: bad", errUsage):            "usage",
+		errCanceled:                                "canceled",
+		errors.New("something else"):               "error",
+	}
+
+	for err, code := range tests {
+		if got := errorCode(err); got != code {
+			t.Errorf("errorCode(%v) = %s, expected %s", err, got, code)
+		}
+	}
+}
+
+func TestJSONHelpAndUsage(t *testing.T) {
+	// Asking a command for help prints it, and succeeds.
+	if output, err := captureError(t, "", func() error { return download([]string{"-h"}) }); exitCode(err) != 0 || !strings.Contains(output, "Commands:") {
+		t.Errorf("Expected -h to print the help and exit with 0, got %v\n%s", err, output)
+	}
+
+	var help map[string]string
+	if err := json.Unmarshal([]byte(captureResults(t, func() error { return dispatch([]string{"keys", "help"}) })), &help); err != nil || !strings.Contains(help["help"], "trust <environment>") {
+		t.Errorf("Unexpected help document: %v (%v)", help, err)
+	}
+
+	// Unknown flags are returned as usage errors, rather than exiting.
+	t.Setenv("COPYCAT_OUTPUT", "json")
+	_, err := captureError(t, "", func() error { return download([]string{"staging", "--bogus"}) })
+	if errorCode(err) != "usage" || !strings.Contains(err.Error(), "bogus") {
+		t.Errorf("Expected a usage error, got %v", err)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"ghst.fr/matthew/copy-cat-env/copycat"
+	"gopkg.in/yaml.v3"
+)
+
+// Main profile entrypoint. Given an array of arguments, handles calling the
+// appropriate sub-function.
+func profile(args []string) error {
+	if len(args) < 1 {
+		return profileUsage("At least one argument is needed")
+	}
+
+	switch args[0] {
+	case "list":
+		return listProfiles()
+	case "show":
+		if len(args) > 2 {
+			return profileUsage("Expected at most 2 argument(s), got " + fmt.Sprint(len(args)))
+		}
+		if len(args) == 2 {
+			return showProfile(args[1])
+		}
+		name, err := activeProfile()
+		if err != nil {
+			return err
+		}
+		return showProfile(name)
+	case "use":
+		if len(args) != 2 {
+			return profileUsage("Expected 2 argument(s), got " + fmt.Sprint(len(args)))
+		}
+		return useProfile(args[1])
+	case "copy", "rename":
+		if len(args) != 3 {
+			return profileUsage("Expected 3 argument(s), got " + fmt.Sprint(len(args)))
+		}
+		return moveProfile(args[0] == "rename", args[1], args[2])
+	case "delete":
+		return deleteProfile(args[1:])
+	case "help":
+		return showHelp("profile")
+	default:
+		return profileUsage("Not a valid option.")
+	}
+}
+
+// Prints a usage error alongside the profile help message, and returns it.
+func profileUsage(message string) error {
+	return topicUsage("profile", message)
+}
+
+// Returns the profile to use: COPYCAT_PROFILE (set by -profile) if given,
+// otherwise the project manifest's, otherwise the one chosen with "copycat
+// profile use". Only resolved by commands which need a profile, as reading the
+// config may migrate the profiles of older versions.
+func activeProfile() (string, error) {
+	if name := os.Getenv("COPYCAT_PROFILE"); name != "" {
+		return name, nil
+	}
+
+	manifest, err := copycat.FindManifest(".")
+	if err != nil && !errors.Is(err, errNotFound) {
+		return "", err
+	}
+	if manifest.Profile != "" {
+		return manifest.Profile, nil
+	}
+
+	return copycat.DefaultProfile()
+}
+
+// Lists every profile, marking the active one.
+func listProfiles() error {
+	names, err := copycat.ListProfiles()
+	if err != nil {
+		return err
+	}
+
+	active, err := activeProfile()
+	if err != nil {
+		return err
+	}
+
+	if len(names) == 0 {
+		fmt.Println("No profiles found. Run " + Info("copycat configure") + " to create one.")
+	}
+	for _, name := range names {
+		if name == active {
+			fmt.Println(OK("* " + name))
+		} else {
+			fmt.Println("  " + name)
+		}
+	}
+
+	return emit(map[string]interface{}{"profiles": names, "active": active})
+}
+
+// Prints the settings of a given profile, with its secret masked.
+func showProfile(name string) error {
+	profile, err := copycat.ReadProfile(name)
+	if errors.Is(err, errNotFound) {
+		fmt.Println(Fata("Profile " + name + " does not exist."))
+		return shown(err)
+	}
+	if err != nil {
+		return err
+	}
+
+	if profile.Secret != "" {
+		profile.Secret = "********"
+	}
+
+	data, err := yaml.Marshal(profile)
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput() {
+		// Round-tripped through YAML, so keys are named as in the config file.
+		var settings map[string]interface{}
+		if err = yaml.Unmarshal(data, &settings); err != nil {
+			return err
+		}
+		return emit(map[string]interface{}{"profile": name, "settings": settings})
+	}
+
+	fmt.Println(Teal("Profile " + name))
+	fmt.Print(string(data))
+
+	return nil
+}
+
+// Persists the profile used when neither -profile nor COPYCAT_PROFILE is given.
+func useProfile(name string) error {
+	if err := copycat.SetDefaultProfile(name); err != nil {
+		if errors.Is(err, errNotFound) {
+			fmt.Println(Fata("Profile " + name + " does not exist."))
+			return shown(err)
+		}
+		return err
+	}
+
+	fmt.Println(OK("Now using profile " + name + " by default."))
+
+	return emit(map[string]string{"profile": name})
+}
+
+// Copies (or renames) a given profile, refusing to overwrite an existing one.
+func moveProfile(rename bool, name string, newName string) error {
+	action, move := "Copying", copycat.CopyProfile
+	if rename {
+		action, move = "Renaming", copycat.RenameProfile
+	}
+
+	fmt.Print(Teal(action + " profile " + name + " to " + newName + "... "))
+
+	if err := move(name, newName); err != nil {
+		fmt.Println(Fata("FAILED!"))
+		fmt.Println(err)
+		return shown(err)
+	}
+
+	fmt.Println(OK("DONE!"))
+
+	return emit(map[string]string{"profile": name, "new_profile": newName})
+}
+
+// Given an array which may contain the following:
+//   - 0: profile to delete
+//   - --yes: skip the confirmation prompt
+//
+// deletes the profile, alongside its secret in the OS keyring.
+func deleteProfile(args []string) error {
+	flags := flag.NewFlagSet("profile delete", flag.ContinueOnError)
+	yes := flags.Bool("yes", false, "do not prompt for confirmation")
+	args, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return profileUsage("Expected a profile to delete")
+	}
+
+	name := args[0]
+
+	if !confirm("Permanently delete profile "+name, *yes) {
+		fmt.Println(Fata("Canceled"))
+		return shown(errCanceled)
+	}
+
+	fmt.Print(Teal("Deleting profile " + name + "... "))
+
+	if err := copycat.DeleteProfile(name); err != nil {
+		fmt.Println(Fata("FAILED!"))
+		fmt.Println(err)
+		return shown(err)
+	}
+
+	fmt.Println(OK("DONE!"))
+
+	return emit(map[string]string{"profile": name})
+}
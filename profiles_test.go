@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"ghst.fr/matthew/copy-cat-env/copycat"
+	"github.com/zalando/go-keyring"
+)
+
+func TestProfiles(t *testing.T) {
+	keyring.MockInit()
+
+	setupProfile(t, copycat.Profile{Backend: "s3", Host: "https://s3.test", Key: "key", Secret: "secret", Bucket: testBucket})
+	if err := copycat.WriteProfile("work", copycat.Profile{Backend: "s3", Key: "key", SecretStore: "keyring"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := copycat.SetKeyringSecret("work", "keyring secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("list", func(t *testing.T) {
+		var listed struct {
+			Profiles []string `json:"profiles"`
+			Active   string   `json:"active"`
+		}
+		json.Unmarshal([]byte(captureResults(t, func() error { return profile([]string{"list"}) })), &listed)
+		if !reflect.DeepEqual(listed.Profiles, []string{"default", "work"}) || listed.Active != "default" {
+			t.Errorf("Unexpected profiles: %+v", listed)
+		}
+	})
+
+	// Secrets are never shown.
+	if output := captureOutput(t, "", func() error { return profile([]string{"show"}) }); strings.Contains(output, "secret: secret") || !strings.Contains(output, "********") {
+		t.Errorf("Secret was not masked:\n%s", output)
+	}
+
+	captureOutput(t, "", func() error { return profile([]string{"copy", "work", "team"}) })
+	if secret, _ := keyring.Get(copycat.KeyringService, "team"); secret != "keyring secret" {
+		t.Errorf("Keyring secret was not copied, got %q", secret)
+	}
+	if _, err := captureError(t, "", func() error { return profile([]string{"copy", "work", "team"}) }); exitCode(err) != 6 {
+		t.Errorf("Expected copying over an existing profile to conflict, got %v", err)
+	}
+
+	captureOutput(t, "", func() error { return profile([]string{"use", "team"}) })
+	if name, _ := activeProfile(); name != "default" {
+		t.Errorf("Expected COPYCAT_PROFILE to take precedence, got %s", name)
+	}
+	t.Setenv("COPYCAT_PROFILE", "")
+	if name, _ := activeProfile(); name != "team" {
+		t.Errorf("Expected the profile in use to be team, got %s", name)
+	}
+
+	// The profile in use follows renames, and falls back to default once
+	// deleted.
+	captureOutput(t, "", func() error { return profile([]string{"rename", "team", "eu"}) })
+	if name, _ := activeProfile(); name != "eu" {
+		t.Errorf("Expected the profile in use to be renamed, got %s", name)
+	}
+	if _, err := keyring.Get(copycat.KeyringService, "team"); err == nil {
+		t.Errorf("Keyring secret of the renamed profile was kept")
+	}
+
+	captureOutput(t, "y\n", func() error { return profile([]string{"delete", "eu"}) })
+	if name, _ := activeProfile(); name != "default" {
+		t.Errorf("Expected the default profile to be used again, got %s", name)
+	}
+	if names, _ := copycat.ListProfiles(); !reflect.DeepEqual(names, []string{"default", "work"}) {
+		t.Errorf("Unexpected profiles after delete: %v", names)
+	}
+
+	if _, err := captureError(t, "", func() error { return profile([]string{"use", "missing"}) }); exitCode(err) != 3 {
+		t.Errorf("Expected using a missing profile to fail with not found, got %v", err)
+	}
+}
+
+func TestDefaultEnvironment(t *testing.T) {
+	setupProfile(t, copycat.Profile{Backend: "fs", Path: t.TempDir(), Environment: "staging"})
+
+	writeFile(t, ".env", "KEY=value\n")
+	captureOutput(t, "", func() error { return dispatch([]string{"upload"}) })
+
+	client, err := getClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := client.GetEnvironment(context.Background(), "staging"); err != nil || string(data) != "KEY=value\n" {
+		t.Errorf("Expected the default environment to be uploaded, got %q (%v)", data, err)
+	}
+
+	// Explicit environments take precedence.
+	captureOutput(t, "", func() error { return dispatch([]string{"upload", "production"}) })
+	if _, err := client.GetEnvironment(context.Background(), "production"); err != nil {
+		t.Errorf("Expected the given environment to be uploaded: %v", err)
+	}
+
+	copycat.WriteProfile("default", copycat.Profile{Backend: "fs", Path: t.TempDir()})
+	if _, err := captureError(t, "", func() error { return dispatch([]string{"download"}) }); exitCode(err) != 2 {
+		t.Errorf("Expected a usage error without a default environment, got %v", err)
+	}
+}
+
+func TestProfileResolvedLazily(t *testing.T) {
+	setupProfile(t, copycat.Profile{Backend: "fs", Path: t.TempDir()})
+	t.Setenv("COPYCAT_PROFILE", "")
+	newReleaseServer(t, map[string]string{"stable": version}, version)
+
+	// A profile written by an older version, waiting to be migrated.
+	config, _ := copycat.ConfigPath()
+	os.Remove(config)
+	legacy := filepath.Join(filepath.Dir(config), "default")
+	writeFile(t, legacy, "BACKEND=fs\nPATH=/tmp\n")
+
+	captureOutput(t, "", func() error { return dispatch([]string{"version"}) })
+	captureOutput(t, "", func() error { return dispatch([]string{"help"}) })
+	refreshUpdateCheck(t)
+
+	if _, err := os.Stat(config); err == nil {
+		t.Errorf("Expected the config not to be migrated by version or help")
+	}
+	if _, err := os.Stat(legacy); err != nil {
+		t.Errorf("Expected the legacy profile to be kept: %v", err)
+	}
+
+	if name, err := activeProfile(); err != nil || name != "default" {
+		t.Errorf("Expected the default profile, got %q (%v)", name, err)
+	}
+	if _, err := os.Stat(config); err != nil {
+		t.Errorf("Expected the config to be migrated once a profile is needed: %v", err)
+	}
+}
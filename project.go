@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"ghst.fr/matthew/copy-cat-env/copycat"
+)
+
+// A local file described by the project manifest, and the object it is stored
+// as in the environment.
+type manifestEntry struct {
+	// Name of the file in the environment, empty for the .env file.
+	name string
+	path string
+}
+
+// Returns the name of the object an entry is stored as.
+func (entry manifestEntry) objectName(env string) string {
+	if entry.name == "" {
+		return "env_" + env
+	}
+
+	return env + "_uploads/" + entry.name
+}
+
+// Returns a description of an entry, to be printed.
+func (entry manifestEntry) String() string {
+	if entry.name == "" {
+		return ".env (" + displayPath(entry.path) + ")"
+	}
+
+	return entry.name + " (" + displayPath(entry.path) + ")"
+}
+
+// Returns path relative to the working directory if it is inside of it.
+func displayPath(path string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return path
+	}
+
+	rel, err := filepath.Rel(wd, path)
+	if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return rel
+	}
+
+	return path
+}
+
+// Returns the project manifest of the working directory (or of one of its
+// parents), or a not found error if there is none.
+func loadManifest() (copycat.Manifest, error) {
+	manifest, err := copycat.FindManifest(".")
+	if errors.Is(err, errNotFound) {
+		fmt.Println("No " + copycat.ManifestName + " found in this directory or its parents. See " + Info("copycat help") + " for its format.")
+		return manifest, shown(err)
+	}
+
+	return manifest, err
+}
+
+// Returns the .env file and every file listed in a manifest.
+func manifestEntries(manifest copycat.Manifest) []manifestEntry {
+	entries := []manifestEntry{{path: manifest.EnvPath()}}
+	for _, file := range manifest.Files {
+		entries = append(entries, manifestEntry{name: file.Name, path: manifest.FilePath(file)})
+	}
+
+	return entries
+}
+
+// Describes the transfers made by pull or push in JSON output.
+type manifestTransfers struct {
+	Environment string         `json:"environment"`
+	Transfers   []transferInfo `json:"transfers"`
+}
+
+// Writes the transfers of every entry as a single JSON document.
+func emitManifestTransfers(client *copycat.Client, env string, entries []manifestEntry) error {
+	if !jsonOutput() {
+		return nil
+	}
+
+	doc := manifestTransfers{Environment: env, Transfers: []transferInfo{}}
+	for _, entry := range entries {
+		info, err := client.Store().Stat(context.Background(), entry.objectName(env))
+		if err != nil {
+			return err
+		}
+		doc.Transfers = append(doc.Transfers, transferInfo{Environment: env, Path: entry.path, Object: info})
+	}
+
+	return emit(doc)
+}
+
+// Given an array which may contain the following:
+//   - 0: environment, the manifest's (or profile's) environment if omitted
+//   - --force: overwrite existing local files
+//
+// restores the .env file and every file listed in the project manifest
+// (.copycat.yml), creating their directories as needed. Unless force is set,
+// nothing is restored if any of them already exists locally.
+func pull(args []string) error {
+	flags := flag.NewFlagSet("pull", flag.ContinueOnError)
+	force := flags.Bool("force", false, "overwrite existing local files")
+	args, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := loadManifest()
+	if err != nil {
+		return err
+	}
+
+	env, err := environmentArg(args)
+	if err != nil {
+		return err
+	}
+
+	entries := manifestEntries(manifest)
+
+	if !*force {
+		for _, entry := range entries {
+			if _, err := os.Stat(entry.path); err == nil {
+				fmt.Println(Fata(displayPath(entry.path)+" already exists. Use ") + Teal("--force") + Fata(" to overwrite it."))
+				return shown(fmt.Errorf("%s: %w", entry.path, errConflict))
+			} else if !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+
+	client, err := getClient()
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		fmt.Print(Teal("Downloading " + entry.String() + " from environment " + env + "... "))
+
+		var data []byte
+		if entry.name == "" {
+			data, err = client.GetEnvironment(context.Background(), env)
+		} else {
+			data, err = client.GetFile(context.Background(), env, entry.name)
+		}
+		if err == nil {
+			err = os.MkdirAll(filepath.Dir(entry.path), 0755)
+		}
+		if err == nil {
+			err = os.WriteFile(entry.path, data, 0644)
+		}
+		if err != nil {
+			fmt.Println(Fata("FAILED!"))
+			return err
+		}
+
+		fmt.Println(OK("DONE!"))
+	}
+
+	return emitManifestTransfers(client, env, entries)
+}
+
+// Given an array which may contain the following:
+//   - 0: environment, the manifest's (or profile's) environment if omitted
+//   - --force: overwrite files already in the environment
+//
+// uploads the .env file and every file listed in the project manifest
+// (.copycat.yml). The version of the .env being overwritten is kept in the
+// environment's history, but other files are only overwritten if force is set.
+// Nothing is uploaded if any local file is missing.
+func push(args []string) error {
+	flags := flag.NewFlagSet("push", flag.ContinueOnError)
+	force := flags.Bool("force", false, "overwrite files already in the environment")
+	args, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := loadManifest()
+	if err != nil {
+		return err
+	}
+
+	env, err := environmentArg(args)
+	if err != nil {
+		return err
+	}
+
+	entries := manifestEntries(manifest)
+
+	contents := make([][]byte, len(entries))
+	for i, entry := range entries {
+		if contents[i], err = os.ReadFile(entry.path); err != nil {
+			return err
+		}
+	}
+
+	client, err := getClient()
+	if err != nil {
+		return err
+	}
+
+	if !*force {
+		for _, entry := range entries[1:] {
+			if _, err = client.StatFile(context.Background(), env, entry.name); err == nil {
+				fmt.Println(Fata(entry.name+" already exists in environment "+env+". Use ") + Teal("--force") + Fata(" to overwrite it."))
+				return shown(fmt.Errorf("%s: %w", entry.name, errConflict))
+			} else if !errors.Is(err, errNotFound) {
+				return err
+			}
+		}
+	}
+
+	for i, entry := range entries {
+		fmt.Print(Teal("Uploading " + entry.String() + " to environment " + env + "... "))
+
+		if entry.name == "" {
+			err = client.PutEnvironment(context.Background(), env, contents[i])
+		} else {
+			err = client.PutFile(context.Background(), env, entry.name, contents[i])
+		}
+		if err != nil {
+			fmt.Println(Fata("FAILED!"))
+			return err
+		}
+
+		fmt.Println(OK("DONE!"))
+	}
+
+	return emitManifestTransfers(client, env, entries)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"ghst.fr/matthew/copy-cat-env/copycat"
+)
+
+const testManifest = `environment: staging
+env_file: config/.env
+files:
+  - name: aws_secrets.txt
+    path: config/aws/credentials
+  - name: service-account.json
+`
+
+func TestPullPush(t *testing.T) {
+	for name, setup := range testBackends {
+		t.Run(name, func(t *testing.T) {
+			dir := setup(t)
+
+			writeFile(t, ".copycat.yml", testManifest)
+			os.MkdirAll(filepath.Join("config", "aws"), 0755)
+			writeFile(t, filepath.Join("config", ".env"), "KEY=value\n")
+			writeFile(t, filepath.Join("config", "aws", "credentials"), "[default]\n")
+			writeFile(t, "service-account.json", "{}\n")
+
+			captureOutput(t, "", func() error { return push(nil) })
+
+			if _, err := captureError(t, "", func() error { return push(nil) }); !errors.Is(err, errConflict) {
+				t.Errorf("Expected pushing over existing files to conflict, got %v", err)
+			}
+			captureOutput(t, "", func() error { return push([]string{"--force"}) })
+
+			// A fresh clone, pulled from a sub-directory.
+			os.RemoveAll("config")
+			os.Remove("service-account.json")
+			os.MkdirAll("src", 0755)
+			chdir(t, filepath.Join(dir, "src"))
+
+			captureOutput(t, "", func() error { return pull(nil) })
+
+			for path, expected := range map[string]string{
+				filepath.Join("config", ".env"):               "KEY=value\n",
+				filepath.Join("config", "aws", "credentials"): "[default]\n",
+				"service-account.json":                        "{}\n",
+			} {
+				if got := readFile(t, filepath.Join(dir, path)); got != expected {
+					t.Errorf("Unexpected contents of %s: %q", path, got)
+				}
+			}
+
+			writeFile(t, filepath.Join(dir, "config", ".env"), "KEY=local\n")
+			if _, err := captureError(t, "", func() error { return pull(nil) }); !errors.Is(err, errConflict) {
+				t.Errorf("Expected pulling over existing files to conflict, got %v", err)
+			}
+			if got := readFile(t, filepath.Join(dir, "config", ".env")); got != "KEY=local\n" {
+				t.Errorf("Local .env was overwritten: %q", got)
+			}
+
+			captureOutput(t, "", func() error { return pull([]string{"--force"}) })
+			if got := readFile(t, filepath.Join(dir, "config", ".env")); got != "KEY=value\n" {
+				t.Errorf("Expected --force to overwrite the local .env, got %q", got)
+			}
+
+			// The manifest's environment is the default of other commands too.
+			if env, err := environmentArg(nil); err != nil || env != "staging" {
+				t.Errorf("Expected the manifest's environment, got %q (%v)", env, err)
+			}
+		})
+	}
+}
+
+func TestManifestProfile(t *testing.T) {
+	setupProfile(t, copycat.Profile{Backend: "fs", Path: t.TempDir()})
+	t.Setenv("COPYCAT_PROFILE", "")
+
+	writeFile(t, ".copycat.yml", "profile: work\n")
+
+	if name, _ := activeProfile(); name != "work" {
+		t.Errorf("Expected the manifest's profile, got %s", name)
+	}
+	t.Setenv("COPYCAT_PROFILE", "other")
+	if name, _ := activeProfile(); name != "other" {
+		t.Errorf("Expected -profile to take precedence, got %s", name)
+	}
+	t.Setenv("COPYCAT_PROFILE", "")
+
+	// An invalid manifest is reported, rather than silently ignored.
+	writeFile(t, ".copycat.yml", "profile: [\n")
+	if _, err := activeProfile(); err == nil {
+		t.Errorf("Expected an invalid manifest to be reported")
+	}
+	if _, err := environmentArg(nil); err == nil {
+		t.Errorf("Expected an invalid manifest to be reported")
+	}
+
+	os.Remove(".copycat.yml")
+	if _, err := captureError(t, "", func() error { return pull(nil) }); !errors.Is(err, errNotFound) {
+		t.Errorf("Expected a missing manifest to be not found, got %v", err)
+	}
+}
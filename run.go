@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// Given an array containing an environment, followed by a command and its
+// arguments, runs the command with the environment's variables injected. The
+// .env file is parsed in memory and never written to disk. A non-zero exit code
+// of the command is returned as an exitStatus, so the program terminates with
+// it.
+func run(args []string) error {
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	args, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if len(args) < 2 {
+		return usage("Expected an environment and a command, i.e. copycat run <environment> -- <cmd> [args...]", false)
+	}
+
+	client, err := getClient()
+	if err != nil {
+		return err
+	}
+
+	vars, err := client.ParseEnvironment(context.Background(), args[0])
+	if err != nil {
+		return err
+	}
+
+	code, err := runWithEnv(vars, args[1], args[2:])
+	if err != nil {
+		return err
+	}
+
+	if code != 0 {
+		return exitStatus(code)
+	}
+
+	return nil
+}
+
+// Runs a command with the given variables merged into (and taking precedence
+// over) the current environment, forwarding any signal received to it. Returns
+// the command's exit code. The command writes to the real stdout, even in JSON
+// mode, as its output is the result.
+func runWithEnv(vars map[string]string, name string, args []string) (int, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, results, os.Stderr
+	cmd.Env = mergeEnv(withoutInternalEnv(os.Environ()), vars)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	defer signal.Stop(signals)
+
+	if err := cmd.Start(); err != nil {
+		return 0, fmt.Errorf("error running %s: %w", name, err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				cmd.Process.Signal(sig)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	err := cmd.Wait()
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		// Follow the shell convention for commands killed by a signal.
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal()), nil
+		}
+
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return 0, nil
+}
+
+// Variables copycat sets or reads for its own use, which are never passed on to
+// the commands it runs (i.e. a passphrase given through COPYCAT_PASSPHRASE).
+var internalEnv = map[string]bool{
+	"COPYCAT_PASSPHRASE": true,
+	"COPYCAT_OUTPUT":     true,
+	"COPYCAT_ENCRYPT":    true,
+	"COPYCAT_KEY_FILE":   true,
+}
+
+// Returns environ (formatted as "KEY=value") without internalEnv.
+func withoutInternalEnv(environ []string) []string {
+	var filtered []string
+	for _, entry := range environ {
+		if key, _, _ := strings.Cut(entry, "="); !internalEnv[key] {
+			filtered = append(filtered, entry)
+		}
+	}
+
+	return filtered
+}
+
+// Returns environ (formatted as "KEY=value") with vars merged in, replacing any
+// existing value.
+func mergeEnv(environ []string, vars map[string]string) []string {
+	var merged []string
+
+	for _, entry := range environ {
+		key, _, _ := strings.Cut(entry, "=")
+		if _, replaced := vars[key]; !replaced {
+			merged = append(merged, entry)
+		}
+	}
+
+	var keys []string
+	for key := range vars {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		merged = append(merged, key+"="+vars[key])
+	}
+
+	return merged
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+func TestMergeEnv(t *testing.T) {
+	environ := []string{"PATH=/bin", "KEY=old", "OTHER=kept"}
+	vars := map[string]string{"KEY": "new", "ADDED": "1"}
+
+	expected := []string{"PATH=/bin", "OTHER=kept", "ADDED=1", "KEY=new"}
+	if merged := mergeEnv(environ, vars); !reflect.DeepEqual(merged, expected) {
+		t.Errorf("Unexpected environment: %v", merged)
+	}
+}
+
+func TestRunWithEnv(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+
+	t.Setenv("KEY", "from the shell")
+
+	// The injected value must win, and the exit code must be passed through.
+	code, err := runWithEnv(map[string]string{"KEY": "injected"}, "sh", []string{"-c", `test "$KEY" = injected && exit 3`})
+	if err != nil || code != 3 {
+		t.Errorf("Expected exit code 3, got %d (%v)", code, err)
+	}
+
+	// copycat's own variables, i.e. the passphrase, are never passed on.
+	t.Setenv("COPYCAT_PASSPHRASE", "hunter2")
+	t.Setenv("COPYCAT_OUTPUT", "json")
+	code, err = runWithEnv(nil, "sh", []string{"-c", `test -z "$COPYCAT_PASSPHRASE$COPYCAT_OUTPUT" && test "$KEY" = "from the shell"`})
+	if err != nil || code != 0 {
+		t.Errorf("Expected internal variables to be removed, got exit code %d (%v)", code, err)
+	}
+
+	code, err = runWithEnv(nil, "sh", []string{"-c", "kill -TERM $$"})
+	if err != nil || code != 128+15 {
+		t.Errorf("Expected exit code 143, got %d (%v)", code, err)
+	}
+}
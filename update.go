@@ -0,0 +1,456 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Base64-encoded ed25519 public key the release index is signed with, set at
+// build time (see the Makefile). Updates are refused without it.
+var UpdatePublicKey string
+
+// Returns the path of the running binary. Replaced in tests.
+var executable = os.Executable
+
+// Returned (wrapped) when a release cannot be verified.
+var errUnverified = errors.New("release could not be verified")
+
+// Channels releases are published to, from the most to the least stable. Each
+// channel also offers the releases of the channels before it.
+var updateChannels = []string{"stable", "beta"}
+
+// The release index ("releases.json" at RELEASE_HOST), listing every release
+// and the latest version of each channel. The index is signed by a detached
+// ed25519 signature, base64-encoded at "releases.json.sig".
+type releaseIndex struct {
+	Channels map[string]string `json:"channels"`
+	Releases []release         `json:"releases"`
+}
+
+// A single release in the index.
+type release struct {
+	Version string `json:"version"`
+
+	// Where the binaries are downloaded from, RELEASE_HOST followed by the
+	// version and a slash if empty.
+	URL string `json:"url,omitempty"`
+
+	// Hex-encoded SHA-256 sum of every binary, by file name.
+	SHA256 map[string]string `json:"sha256"`
+}
+
+// Returns the name of the binary released for the current platform.
+func assetName() string {
+	name := "copycat-" + runtime.GOOS + "-" + runtime.GOARCH
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+
+	return name
+}
+
+// Returns the URL of path below RELEASE_HOST, with or without a trailing
+// slash.
+func releaseURL(path string) string {
+	return strings.TrimSuffix(os.Getenv("RELEASE_HOST"), "/") + "/" + path
+}
+
+// Downloads the contents of url using client, failing on any status other than
+// 200 OK.
+func fetch(client *http.Client, url string) ([]byte, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP error: %s returned %s", url, resp.Status)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// Fetches the release index at url using client, and verifies its signature
+// against UpdatePublicKey.
+func fetchIndex(client *http.Client, url string) (releaseIndex, error) {
+	key, err := base64.StdEncoding.DecodeString(UpdatePublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return releaseIndex{}, fmt.Errorf("%w: this build has no valid update public key", errUnverified)
+	}
+
+	data, err := fetch(client, url)
+	if err != nil {
+		return releaseIndex{}, err
+	}
+
+	encoded, err := fetch(client, url+".sig")
+	if err != nil {
+		return releaseIndex{}, err
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil || !ed25519.Verify(key, data, signature) {
+		return releaseIndex{}, fmt.Errorf("%w: invalid signature", errUnverified)
+	}
+
+	var index releaseIndex
+	if err = json.Unmarshal(data, &index); err != nil {
+		return releaseIndex{}, fmt.Errorf("invalid release index: %w", err)
+	}
+
+	for _, release := range index.Releases {
+		if _, ok := parseVersion(release.Version); !ok {
+			return releaseIndex{}, fmt.Errorf("invalid release index: invalid version %q", release.Version)
+		}
+	}
+
+	return index, nil
+}
+
+// Returns the release of a given version, if listed.
+func (index releaseIndex) find(version string) (release, bool) {
+	for _, release := range index.Releases {
+		if release.Version == version {
+			return release, true
+		}
+	}
+
+	return release{}, false
+}
+
+// Returns the latest release offered by a given channel, which includes the
+// releases of every more stable channel.
+func (index releaseIndex) latest(channel string) (release, error) {
+	var latest release
+	found := false
+
+	for _, name := range updateChannels {
+		if version, ok := index.Channels[name]; ok {
+			candidate, listed := index.find(version)
+			if !listed {
+				return release{}, fmt.Errorf("invalid release index: %s release %s is not listed", name, version)
+			}
+			if !found || compareVersions(candidate.Version, latest.Version) > 0 {
+				latest, found = candidate, true
+			}
+		}
+
+		if name == channel {
+			if !found {
+				return release{}, fmt.Errorf("no release on the %s channel: %w", channel, errNotFound)
+			}
+			return latest, nil
+		}
+	}
+
+	return release{}, usage("Unknown channel: "+channel+", expected one of "+strings.Join(updateChannels, ", "), false)
+}
+
+// A parsed semantic version, i.e. v1.4.0 or v1.5.0-beta.2.
+type semver struct {
+	numbers    [3]int
+	prerelease []string
+}
+
+// Parses a semantic version, with a leading "v". Build metadata is ignored.
+func parseVersion(version string) (semver, bool) {
+	if !strings.HasPrefix(version, "v") {
+		return semver{}, false
+	}
+
+	version, _, _ = strings.Cut(version[1:], "+")
+	core, prerelease, hasPrerelease := strings.Cut(version, "-")
+
+	var parsed semver
+	parts := strings.Split(core, ".")
+	if len(parts) != 3 {
+		return semver{}, false
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || (len(part) > 1 && part[0] == '0') {
+			return semver{}, false
+		}
+		parsed.numbers[i] = n
+	}
+
+	if hasPrerelease {
+		parsed.prerelease = strings.Split(prerelease, ".")
+		for _, identifier := range parsed.prerelease {
+			if identifier == "" {
+				return semver{}, false
+			}
+		}
+	}
+
+	return parsed, true
+}
+
+// Compares two semantic versions following semver precedence, returning -1,
+// 0 or 1. Versions which cannot be parsed sort before any valid version.
+func compareVersions(a string, b string) int {
+	va, okA := parseVersion(a)
+	vb, okB := parseVersion(b)
+	if !okA || !okB {
+		switch {
+		case okA:
+			return 1
+		case okB:
+			return -1
+		}
+		return strings.Compare(a, b)
+	}
+
+	for i := range va.numbers {
+		if va.numbers[i] != vb.numbers[i] {
+			return compareInts(va.numbers[i], vb.numbers[i])
+		}
+	}
+
+	// A pre-release has a lower precedence than the release itself.
+	switch {
+	case len(va.prerelease) == 0 && len(vb.prerelease) == 0:
+		return 0
+	case len(va.prerelease) == 0:
+		return 1
+	case len(vb.prerelease) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(va.prerelease) && i < len(vb.prerelease); i++ {
+		x, y := va.prerelease[i], vb.prerelease[i]
+		nx, errX := strconv.Atoi(x)
+		ny, errY := strconv.Atoi(y)
+
+		switch {
+		case errX == nil && errY == nil:
+			if nx != ny {
+				return compareInts(nx, ny)
+			}
+		// Numeric identifiers have a lower precedence than alphanumeric ones.
+		case errX == nil:
+			return -1
+		case errY == nil:
+			return 1
+		default:
+			if x != y {
+				return strings.Compare(x, y)
+			}
+		}
+	}
+
+	return compareInts(len(va.prerelease), len(vb.prerelease))
+}
+
+func compareInts(a int, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// Given an array which may contain the following:
+//   - --channel: channel to update from (stable or beta)
+//   - --to: version to install instead of the latest, allowing downgrades
+//   - --check: only report whether an update is available
+//   - --yes: do not prompt to confirm the installation directory
+//
+// checks if a newer version has been released, and replaces the CopyCat
+// binary. The release is only installed if the index is signed by
+// UpdatePublicKey and its SHA-256 sum matches. The new binary is staged next to
+// the current one, swapped in with a single rename, and rolled back if it fails
+// to run.
+func update(args []string) error {
+	flags := flag.NewFlagSet("update", flag.ContinueOnError)
+	channel := flags.String("channel", "stable", "release channel: "+strings.Join(updateChannels, " or "))
+	to := flags.String("to", "", "version to install, i.e. v1.4.0")
+	check := flags.Bool("check", false, "only check whether an update is available")
+	yes := flags.Bool("yes", false, "do not prompt for confirmation")
+	args, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 0 {
+		return usage("Unexpected argument(s): "+strings.Join(args, " "), false)
+	}
+
+	fmt.Print("Checking if update exists... ")
+
+	index, err := fetchIndex(http.DefaultClient, releaseURL("releases.json"))
+	if err != nil {
+		fmt.Println(Fata("FAILED!"))
+		return err
+	}
+
+	var target release
+	if *to != "" {
+		var ok bool
+		if target, ok = index.find(*to); !ok {
+			fmt.Println(Fata("FAILED!"))
+			return fmt.Errorf("release %s: %w", *to, errNotFound)
+		}
+	} else if target, err = index.latest(*channel); err != nil {
+		fmt.Println(Fata("FAILED!"))
+		return err
+	}
+
+	ver := target.Version
+
+	// Without an explicit version, older releases are never offered.
+	if ver == version || (*to == "" && compareVersions(ver, version) < 0) {
+		fmt.Println(Teal("NONE!"))
+		fmt.Println(Warn("You already have the latest version: ") + OK(version))
+		return emit(map[string]interface{}{"version": version, "latest": ver, "channel": *channel, "available": false, "updated": false})
+	}
+
+	fmt.Println(OK("FOUND! ") + Fata(version) + " -> " + OK(ver))
+
+	if *check {
+		fmt.Println("Run " + Info("copycat update") + " to install it.")
+		return emit(map[string]interface{}{"version": version, "latest": ver, "channel": *channel, "available": true, "updated": false})
+	}
+
+	sum, err := hex.DecodeString(target.SHA256[assetName()])
+	if err != nil || len(sum) != sha256.Size {
+		return fmt.Errorf("%w: no checksum for %s", errUnverified, assetName())
+	}
+
+	// Get path of where executable is installed.
+	fmt.Printf("Locating installation location... ")
+
+	ex, err := executable()
+	if err == nil {
+		ex, err = filepath.EvalSymlinks(ex)
+	}
+	if err != nil {
+		fmt.Println(Fata("FAILED!"))
+		return err
+	}
+
+	fmt.Println(OK("FOUND!"))
+	installDir := filepath.Dir(ex)
+
+	if !confirm("Confirm installation directory ("+installDir+")", *yes) {
+		fmt.Println(Fata("Aborting!"))
+		return shown(errCanceled)
+	}
+
+	base := target.URL
+	if base == "" {
+		base = releaseURL(ver + "/")
+	}
+	url := base + assetName()
+
+	fmt.Print(Teal("Fetching " + ver + " from " + url + "... "))
+
+	staged, err := stageRelease(installDir, url, sum)
+	if err != nil {
+		fmt.Println(Fata("FAILED!"))
+		return err
+	}
+	defer os.Remove(staged)
+
+	fmt.Println(OK("VERIFIED!"))
+
+	fmt.Print("Installing " + Info(ver) + " to " + Info(ex) + "... ")
+
+	if err = swapBinary(ex, staged, ver); err != nil {
+		fmt.Println(Fata("FAILED!"))
+		return err
+	}
+
+	fmt.Println(OK("DONE!"))
+	fmt.Println(Info("Successfully updated to " + ver + "!"))
+
+	return emit(map[string]interface{}{"version": ver, "previous": version, "channel": *channel, "available": true, "updated": true})
+}
+
+// Downloads the binary at url into a new file in dir, and checks its SHA-256
+// sum. Returns the path of the (executable) file.
+func stageRelease(dir string, url string, sum []byte) (string, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return "", fmt.Errorf("HTTP error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("HTTP error: %s returned %s", url, resp.Status)
+	}
+
+	// Staged in the install directory, so it can be renamed into place.
+	file, err := os.CreateTemp(dir, ".copycat-update-*")
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(file, hash), resp.Body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && !bytes.Equal(hash.Sum(nil), sum) {
+		err = fmt.Errorf("%w: checksum mismatch for %s", errUnverified, url)
+	}
+	if err == nil {
+		err = os.Chmod(file.Name(), 0755)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+
+	return file.Name(), nil
+}
+
+// Replaces the binary at path with staged, expecting "version-clean" to print
+// ver afterwards. The previous binary is restored otherwise.
+func swapBinary(path string, staged string, ver string) error {
+	backup := path + ".old"
+	os.Remove(backup)
+
+	// Hard linking keeps the binary in place until the rename replaces it.
+	// Where that is not possible (i.e. on Windows, where a running binary
+	// cannot be replaced), it is moved aside first.
+	if err := os.Link(path, backup); err != nil {
+		if err = os.Rename(path, backup); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(staged, path); err != nil {
+		os.Rename(backup, path)
+		return err
+	}
+
+	output, err := exec.Command(path, "version-clean").Output()
+	if got := strings.TrimSpace(string(output)); err != nil || got != ver {
+		if rollbackErr := os.Rename(backup, path); rollbackErr != nil {
+			return fmt.Errorf("new binary failed its self-test, and rolling back failed: %w (previous binary kept at %s)", rollbackErr, backup)
+		}
+		return fmt.Errorf("new binary failed its self-test (printed %q, %v), rolled back", got, err)
+	}
+
+	os.Remove(backup)
+
+	return nil
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// A release server, serving a signed release index and the binaries it lists.
+type releaseServer struct {
+	*httptest.Server
+	files map[string]string
+}
+
+// Starts a release server, listing a release for each given binary (by
+// version) and the given latest version of each channel. Binaries are shell
+// scripts printing their version.
+func newReleaseServer(t *testing.T, channels map[string]string, versions ...string) *releaseServer {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous := UpdatePublicKey
+	UpdatePublicKey = base64.StdEncoding.EncodeToString(public)
+	t.Cleanup(func() { UpdatePublicKey = previous })
+
+	server := &releaseServer{files: map[string]string{}}
+	index := releaseIndex{Channels: channels}
+
+	for _, ver := range versions {
+		binary := fakeBinary(ver)
+		sum := sha256.Sum256([]byte(binary))
+
+		server.files["/"+ver+"/"+assetName()] = binary
+		index.Releases = append(index.Releases, release{Version: ver, SHA256: map[string]string{assetName(): hex.EncodeToString(sum[:])}})
+	}
+
+	data, _ := json.Marshal(index)
+	server.files["/releases.json"] = string(data)
+	server.files["/releases.json.sig"] = base64.StdEncoding.EncodeToString(ed25519.Sign(private, data))
+
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := server.files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(data))
+	}))
+	t.Cleanup(server.Close)
+
+	t.Setenv("RELEASE_HOST", server.URL)
+
+	return server
+}
+
+// Returns a fake copycat binary, printing the given version.
+func fakeBinary(ver string) string {
+	return "#!/bin/sh\necho " + ver + "\n"
+}
+
+// Installs a fake copycat binary printing the given version, and makes it the
+// running executable. Returns its path.
+func installFakeBinary(t *testing.T, ver string) string {
+	path := filepath.Join(t.TempDir(), "copycat")
+	writeFile(t, path, fakeBinary(ver))
+	os.Chmod(path, 0755)
+
+	previous := executable
+	executable = func() (string, error) { return path, nil }
+	t.Cleanup(func() { executable = previous })
+
+	return path
+}
+
+// Returns the version printed by the fake binary at path.
+func installedVersion(t *testing.T, path string) string {
+	data, _ := os.ReadFile(path)
+	for ver := range map[string]bool{version: true, "v9.9.9": true, "v10.0.0-beta.1": true, "v1.4.0": true} {
+		if string(data) == fakeBinary(ver) {
+			return ver
+		}
+	}
+
+	return string(data)
+}
+
+func TestUpdate(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake binaries are shell scripts")
+	}
+
+	channels := map[string]string{"stable": "v9.9.9", "beta": "v10.0.0-beta.1"}
+	versions := []string{"v1.4.0", "v9.9.9", "v10.0.0-beta.1"}
+
+	t.Run("verified", func(t *testing.T) {
+		newReleaseServer(t, channels, versions...)
+		path := installFakeBinary(t, version)
+
+		captureOutput(t, "y\n", func() error { return update(nil) })
+
+		if ver := installedVersion(t, path); ver != "v9.9.9" {
+			t.Errorf("Expected v9.9.9 to be installed, got %s", ver)
+		}
+		if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+			t.Errorf("Expected staged and old binaries to be removed, got %d file(s)", len(entries))
+		}
+	})
+
+	t.Run("beta", func(t *testing.T) {
+		newReleaseServer(t, channels, versions...)
+		path := installFakeBinary(t, version)
+
+		captureOutput(t, "", func() error { return update([]string{"--channel", "beta", "--yes"}) })
+		if ver := installedVersion(t, path); ver != "v10.0.0-beta.1" {
+			t.Errorf("Expected the beta to be installed, got %s", ver)
+		}
+	})
+
+	t.Run("beta behind stable", func(t *testing.T) {
+		newReleaseServer(t, map[string]string{"stable": "v9.9.9", "beta": "v9.9.9-rc.1"}, "v9.9.9", "v9.9.9-rc.1")
+		path := installFakeBinary(t, version)
+
+		captureOutput(t, "", func() error { return update([]string{"--channel", "beta", "--yes"}) })
+		if ver := installedVersion(t, path); ver != "v9.9.9" {
+			t.Errorf("Expected the newer stable release to be installed, got %s", ver)
+		}
+	})
+
+	t.Run("no downgrade", func(t *testing.T) {
+		newReleaseServer(t, map[string]string{"stable": "v1.4.0"}, "v1.4.0")
+		path := installFakeBinary(t, version)
+
+		output := captureOutput(t, "", func() error { return update([]string{"--yes"}) })
+		if ver := installedVersion(t, path); ver != version {
+			t.Errorf("Expected no downgrade, got %s\n%s", ver, output)
+		}
+
+		// Unless pinned explicitly.
+		captureOutput(t, "", func() error { return update([]string{"--to", "v1.4.0", "--yes"}) })
+		if ver := installedVersion(t, path); ver != "v1.4.0" {
+			t.Errorf("Expected v1.4.0 to be installed, got %s", ver)
+		}
+
+		if _, err := captureError(t, "", func() error { return update([]string{"--to", "v0.1.0"}) }); !errors.Is(err, errNotFound) {
+			t.Errorf("Expected an unknown version to be not found, got %v", err)
+		}
+	})
+
+	t.Run("check", func(t *testing.T) {
+		newReleaseServer(t, channels, versions...)
+		path := installFakeBinary(t, version)
+
+		var checked struct {
+			Latest    string `json:"latest"`
+			Available bool   `json:"available"`
+			Updated   bool   `json:"updated"`
+		}
+		json.Unmarshal([]byte(captureResults(t, func() error { return update([]string{"--check"}) })), &checked)
+		if checked.Latest != "v9.9.9" || !checked.Available || checked.Updated {
+			t.Errorf("Unexpected check result: %+v", checked)
+		}
+		if ver := installedVersion(t, path); ver != version {
+			t.Errorf("Expected nothing to be installed, got %s", ver)
+		}
+	})
+
+	t.Run("checksum", func(t *testing.T) {
+		server := newReleaseServer(t, channels, versions...)
+		server.files["/v9.9.9/"+assetName()] = "#!/bin/sh\necho tampered\n"
+		path := installFakeBinary(t, version)
+
+		if _, err := captureError(t, "y\n", func() error { return update(nil) }); !errors.Is(err, errUnverified) {
+			t.Errorf("Expected a checksum mismatch, got %v", err)
+		}
+		if ver := installedVersion(t, path); ver != version {
+			t.Errorf("Tampered binary was installed: %s", ver)
+		}
+		if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+			t.Errorf("Expected the staged binary to be removed, got %d file(s)", len(entries))
+		}
+	})
+
+	t.Run("signature", func(t *testing.T) {
+		newReleaseServer(t, channels, versions...)
+		installFakeBinary(t, version)
+
+		// Signed by another key.
+		other, _, _ := ed25519.GenerateKey(rand.Reader)
+		UpdatePublicKey = base64.StdEncoding.EncodeToString(other)
+
+		if _, err := captureError(t, "y\n", func() error { return update(nil) }); !errors.Is(err, errUnverified) {
+			t.Errorf("Expected an invalid signature, got %v", err)
+		}
+
+		UpdatePublicKey = ""
+		if _, err := captureError(t, "y\n", func() error { return update(nil) }); !errors.Is(err, errUnverified) {
+			t.Errorf("Expected updates to be refused without a key, got %v", err)
+		}
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		server := newReleaseServer(t, map[string]string{"stable": "v9.9.9"}, "v9.9.9")
+		path := installFakeBinary(t, version)
+
+		// A binary matching its sum, which fails to run.
+		broken := "#!/bin/sh\nexit 1\n"
+		sum := sha256.Sum256([]byte(broken))
+		server.files["/v9.9.9/"+assetName()] = broken
+		index := releaseIndex{Channels: map[string]string{"stable": "v9.9.9"}, Releases: []release{{Version: "v9.9.9", SHA256: map[string]string{assetName(): hex.EncodeToString(sum[:])}}}}
+		public, private, _ := ed25519.GenerateKey(rand.Reader)
+		UpdatePublicKey = base64.StdEncoding.EncodeToString(public)
+		data, _ := json.Marshal(index)
+		server.files["/releases.json"] = string(data)
+		server.files["/releases.json.sig"] = base64.StdEncoding.EncodeToString(ed25519.Sign(private, data))
+
+		if _, err := captureError(t, "y\n", func() error { return update(nil) }); err == nil {
+			t.Errorf("Expected the self-test to fail")
+		}
+		if ver := installedVersion(t, path); ver != version {
+			t.Errorf("Previous binary was not restored: %s", ver)
+		}
+	})
+
+	t.Run("status", func(t *testing.T) {
+		server := newReleaseServer(t, channels, versions...)
+		installFakeBinary(t, version)
+		t.Setenv("RELEASE_HOST", server.URL+"/missing/")
+
+		if _, err := captureError(t, "y\n", func() error { return update(nil) }); err == nil {
+			t.Errorf("Expected a missing index to fail")
+		}
+	})
+}
+
+func TestCompareVersions(t *testing.T) {
+	// Each version is lower than the next.
+	ordered := []string{
+		"not a version",
+		"v1.0.0-alpha",
+		"v1.0.0-alpha.1",
+		"v1.0.0-alpha.beta",
+		"v1.0.0-beta",
+		"v1.0.0-beta.2",
+		"v1.0.0-beta.11",
+		"v1.0.0-rc.1",
+		"v1.0.0",
+		"v1.4.0",
+		"v1.10.0",
+		"v2.0.0",
+	}
+
+	for i := range ordered {
+		for j := range ordered {
+			expected := compareInts(i, j)
+			if got := compareVersions(ordered[i], ordered[j]); got != expected {
+				t.Errorf("compareVersions(%s, %s) = %d, expected %d", ordered[i], ordered[j], got, expected)
+			}
+		}
+	}
+
+	if compareVersions("v1.0.0+build.1", "v1.0.0") != 0 {
+		t.Errorf("Expected build metadata to be ignored")
+	}
+	for _, invalid := range []string{"1.0.0", "v1.0", "v01.0.0", "v1.0.0-", "v1.0.0-beta..1"} {
+		if _, ok := parseVersion(invalid); ok {
+			t.Errorf("Expected %s to be invalid", invalid)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"ghst.fr/matthew/copy-cat-env/copycat"
+)
+
+// Name of the file (in the config directory) caching the last update check.
+// Hidden, so it is never mistaken for a legacy profile when migrating.
+const updateCheckFile = ".update-check.json"
+
+// How long the result of an update check is reused for.
+const updateCheckInterval = 24 * time.Hour
+
+// How long fetching the release index may take during an update check.
+var updateCheckTimeout = 2 * time.Second
+
+// Result of the last update check.
+type updateCheck struct {
+	CheckedAt time.Time `json:"checked_at"`
+
+	// Latest stable version, empty if it could not be fetched.
+	Latest string `json:"latest,omitempty"`
+}
+
+// Commands never followed by an update notice: update reports on its own,
+// version-clean is parsed by scripts and the updater, and run must not add to
+// the output of the command it runs.
+var noUpdateCheck = map[string]bool{"update": true, "version-clean": true, "run": true}
+
+// Starts checking for a newer stable release before running the command given
+// by args, unless disabled by COPYCAT_NO_UPDATE_CHECK or the profile's
+// no_update_check. The release index is fetched at most once a day, in the
+// background, and the result cached in the config directory.
+//
+// The returned function prints a one-line notice to w if the cache holds a
+// newer release. It never waits for a pending check, whose result is only
+// reported once cached, and never fails: checks which cannot complete (i.e.
+// offline) are silently retried the next day.
+func checkForUpdate(args []string) func(w io.Writer) {
+	skip := func(io.Writer) {}
+
+	if len(args) == 0 || noUpdateCheck[args[0]] || os.Getenv("COPYCAT_NO_UPDATE_CHECK") != "" {
+		return skip
+	}
+	if os.Getenv("RELEASE_HOST") == "" || UpdatePublicKey == "" {
+		return skip
+	}
+	if profile, err := readProfileIfConfigured(); err == nil && profile.NoUpdateCheck {
+		return skip
+	}
+
+	dir, err := copycat.ConfigDir()
+	if err != nil {
+		return skip
+	}
+	path := filepath.Join(dir, updateCheckFile)
+
+	if cached := readUpdateCheck(path); time.Since(cached.CheckedAt) >= updateCheckInterval {
+		go func() {
+			check := updateCheck{CheckedAt: time.Now(), Latest: cached.Latest}
+
+			client := &http.Client{Timeout: updateCheckTimeout}
+			if index, err := fetchIndex(client, releaseURL("releases.json")); err == nil {
+				if latest, err := index.latest("stable"); err == nil {
+					check.Latest = latest.Version
+				}
+			}
+
+			writeUpdateCheck(path, check)
+		}()
+	}
+
+	return func(w io.Writer) { printUpdateNotice(w, readUpdateCheck(path).Latest) }
+}
+
+// Returns the cached update check at path, or a zero one if there is none.
+func readUpdateCheck(path string) updateCheck {
+	var check updateCheck
+
+	data, err := os.ReadFile(path)
+	if err == nil && json.Unmarshal(data, &check) != nil {
+		return updateCheck{}
+	}
+
+	return check
+}
+
+// Caches an update check at path, ignoring any error.
+func writeUpdateCheck(path string, check updateCheck) {
+	data, err := json.Marshal(check)
+	if err != nil {
+		return
+	}
+
+	if os.MkdirAll(filepath.Dir(path), 0700) == nil {
+		os.WriteFile(path, data, 0600)
+	}
+}
+
+// Prints a notice to w if latest is newer than the running version.
+func printUpdateNotice(w io.Writer, latest string) {
+	if latest == "" || compareVersions(latest, version) <= 0 {
+		return
+	}
+
+	io.WriteString(w, Warn("A new release of copycat is available: ")+Fata(version)+" -> "+OK(latest)+". Run "+Info("copycat update")+" to install it.\n")
+}
+
+// Reads the active profile, as long as the config file already exists. Reading
+// it otherwise may migrate the profiles of older versions, which is left to
+// the commands that need a profile.
+func readProfileIfConfigured() (copycat.Profile, error) {
+	path, err := copycat.ConfigPath()
+	if err != nil {
+		return copycat.Profile{}, err
+	}
+	if _, err = os.Stat(path); err != nil {
+		return copycat.Profile{}, err
+	}
+
+	name, err := activeProfile()
+	if err != nil {
+		return copycat.Profile{}, err
+	}
+
+	return copycat.ReadProfile(name)
+}
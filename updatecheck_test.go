@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ghst.fr/matthew/copy-cat-env/copycat"
+)
+
+// Runs checkForUpdate for a given command, returning the notice it printed.
+func updateNotice(args ...string) string {
+	var notice bytes.Buffer
+	checkForUpdate(args)(&notice)
+
+	return notice.String()
+}
+
+// Starts an update check, and waits for its result to be cached.
+func refreshUpdateCheck(t *testing.T) {
+	t.Helper()
+
+	dir, _ := copycat.ConfigDir()
+	previous := readUpdateCheck(filepath.Join(dir, updateCheckFile)).CheckedAt
+
+	checkForUpdate([]string{"list"})
+	waitForUpdateCheck(t, previous)
+}
+
+// Waits for an update check made after the previous one to be cached.
+func waitForUpdateCheck(t *testing.T, previous time.Time) {
+	t.Helper()
+
+	dir, _ := copycat.ConfigDir()
+	path := filepath.Join(dir, updateCheckFile)
+
+	deadline := time.Now().Add(updateCheckTimeout + time.Second)
+	for readUpdateCheck(path).CheckedAt.Equal(previous) {
+		if time.Now().After(deadline) {
+			t.Fatal("The update check was not cached")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCheckForUpdate(t *testing.T) {
+	channels := map[string]string{"stable": "v9.9.9", "beta": "v10.0.0-beta.1"}
+	versions := []string{"v9.9.9", "v10.0.0-beta.1"}
+
+	t.Run("notice", func(t *testing.T) {
+		setupProfile(t, copycat.Profile{Backend: "fs", Path: t.TempDir()})
+		server := newReleaseServer(t, channels, versions...)
+
+		refreshUpdateCheck(t)
+		notice := updateNotice("list")
+		if !strings.Contains(notice, "v9.9.9") || strings.Count(notice, "\n") != 1 {
+			t.Errorf("Expected a one-line notice about the stable release, got %q", notice)
+		}
+
+		dir, _ := copycat.ConfigDir()
+		var check updateCheck
+		json.Unmarshal([]byte(readFile(t, filepath.Join(dir, updateCheckFile))), &check)
+		if check.Latest != "v9.9.9" || time.Since(check.CheckedAt) > time.Minute {
+			t.Errorf("Unexpected cached check: %+v", check)
+		}
+
+		// Cached for a day, so the index is not fetched again.
+		delete(server.files, "/releases.json")
+		if notice := updateNotice("list"); !strings.Contains(notice, "v9.9.9") {
+			t.Errorf("Expected the cached check to be used, got %q", notice)
+		}
+
+		// Not for update itself.
+		if notice := updateNotice("update"); notice != "" {
+			t.Errorf("Expected no notice for update, got %q", notice)
+		}
+	})
+
+	t.Run("stale", func(t *testing.T) {
+		setupProfile(t, copycat.Profile{Backend: "fs", Path: t.TempDir()})
+		newReleaseServer(t, map[string]string{"stable": version}, version)
+
+		dir, _ := copycat.ConfigDir()
+		writeUpdateCheck(filepath.Join(dir, updateCheckFile), updateCheck{CheckedAt: time.Now().Add(-48 * time.Hour), Latest: "v9.9.9"})
+
+		refreshUpdateCheck(t)
+		if notice := updateNotice("list"); notice != "" {
+			t.Errorf("Expected the stale check to be refreshed, got %q", notice)
+		}
+	})
+
+	t.Run("silenced", func(t *testing.T) {
+		setupProfile(t, copycat.Profile{Backend: "fs", Path: t.TempDir(), NoUpdateCheck: true})
+		newReleaseServer(t, channels, versions...)
+
+		if notice := updateNotice("list"); notice != "" {
+			t.Errorf("Expected no_update_check to silence the check, got %q", notice)
+		}
+
+		copycat.WriteProfile("default", copycat.Profile{Backend: "fs", Path: t.TempDir()})
+		t.Setenv("COPYCAT_NO_UPDATE_CHECK", "1")
+		if notice := updateNotice("list"); notice != "" {
+			t.Errorf("Expected COPYCAT_NO_UPDATE_CHECK to silence the check, got %q", notice)
+		}
+
+		dir, _ := copycat.ConfigDir()
+		if _, err := os.Stat(filepath.Join(dir, updateCheckFile)); err == nil {
+			t.Errorf("Expected no check to be made")
+		}
+	})
+
+	t.Run("offline", func(t *testing.T) {
+		setupProfile(t, copycat.Profile{Backend: "fs", Path: t.TempDir()})
+		server := newReleaseServer(t, channels, versions...)
+		server.Close()
+
+		refreshUpdateCheck(t)
+		if notice := updateNotice("list"); notice != "" {
+			t.Errorf("Expected no notice offline, got %q", notice)
+		}
+
+		// Not retried until the next day.
+		dir, _ := copycat.ConfigDir()
+		if check := readUpdateCheck(filepath.Join(dir, updateCheckFile)); check.CheckedAt.IsZero() {
+			t.Errorf("Expected the failed check to be cached")
+		}
+	})
+
+	// The command never waits for a pending check, its notice comes from the
+	// cache instead.
+	t.Run("pending", func(t *testing.T) {
+		setupProfile(t, copycat.Profile{Backend: "fs", Path: t.TempDir()})
+		newReleaseServer(t, channels, versions...)
+
+		release := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-release }))
+		t.Cleanup(server.Close)
+		t.Setenv("RELEASE_HOST", server.URL)
+
+		dir, _ := copycat.ConfigDir()
+		stale := time.Now().Add(-48 * time.Hour)
+		writeUpdateCheck(filepath.Join(dir, updateCheckFile), updateCheck{CheckedAt: stale, Latest: "v9.9.9"})
+
+		start := time.Now()
+		var notice bytes.Buffer
+		checkForUpdate([]string{"list"})(&notice)
+		if time.Since(start) > updateCheckTimeout/2 {
+			t.Errorf("Expected the notice not to wait for the pending check, took %s", time.Since(start))
+		}
+		if !strings.Contains(notice.String(), "v9.9.9") {
+			t.Errorf("Expected the cached release to be reported, got %q", notice.String())
+		}
+
+		close(release)
+		waitForUpdateCheck(t, stale)
+	})
+}
@@ -3,97 +3,130 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
-	"log"
-	"net/http"
 	"os"
 	"strconv"
 	"strings"
 
-	"github.com/joho/godotenv"
+	"ghst.fr/matthew/copy-cat-env/copycat"
 	"github.com/minio/minio-go/v7"
-	"github.com/minio/minio-go/v7/pkg/credentials"
+	"golang.org/x/term"
 )
 
-// Used for internal error handling, as the code for error handling can become
-// quite repetitive.
-func handleError(err error, failed bool, exit bool) {
+// Checks whether a given profile exists.
+func profileExists(profile string) (bool, error) {
+	names, err := copycat.ListProfiles()
 	if err != nil {
+		return false, err
+	}
 
-		if failed {
-			fmt.Println(Fata("FAILED!"))
+	for _, name := range names {
+		if name == profile {
+			return true, nil
 		}
+	}
 
-		log.Fatal(err)
+	return false, nil
+}
+
+// Returns the name and settings of the active profile. The profile is read
+// rather than loaded into the process environment, as keys such as PATH and
+// HOSTNAME are almost always already set by the shell.
+func loadProfile() (string, copycat.Profile, error) {
+	name, err := activeProfile()
+	if err != nil {
+		return "", copycat.Profile{}, err
+	}
 
-		if exit {
-			os.Exit(1)
+	profile, err := copycat.ReadProfile(name)
+	if errors.Is(err, errNotFound) {
+		fmt.Println("Configuration does not exist. Run " + Info("copycat configure") + " to create configuration file.")
+		return "", copycat.Profile{}, shown(err)
+	}
+	if errors.Is(err, copycat.ErrInsecureProfile) {
+		config, _ := copycat.ConfigPath()
+		fmt.Println(Fata("Refusing to use a profile other users can read. Run ") + Teal("chmod 600 "+config) + Fata(" to fix it."))
+		return "", copycat.Profile{}, shown(err)
+	}
+	if err != nil {
+		return "", copycat.Profile{}, err
+	}
+
+	if dir, err := copycat.ConfigDir(); err == nil {
+		if info, err := os.Stat(dir); err == nil && copycat.InsecurePermissions(info.Mode()) {
+			fmt.Println(Warn("Warning: "+dir+" is accessible by other users. Run ") + Teal("chmod 700 "+dir) + Warn(" to fix it."))
 		}
 	}
-}
 
-// Given a host, key, and it's secret, return a new minioClient.
-func createClient(host string, key string, secret string) (*minio.Client, error) {
-	useSSL := true
-	var endpoint string
+	return name, profile, nil
+}
 
-	if strings.Contains(host, "http://") {
-		useSSL = false
-		endpoint = strings.Replace(host, "http://", "", 1)
-	} else {
-		endpoint = strings.Replace(host, "https://", "", 1)
+// Returns a client for the active profile. The -encrypt and -key-file flags
+// take precedence over the profile's encryption settings, and passphrases are
+// read from COPYCAT_PASSPHRASE or prompted for.
+func getClient() (*copycat.Client, error) {
+	name, profile, err := loadProfile()
+	if err != nil {
+		return nil, err
 	}
 
-	// Initialize minio client object.
-	minioClient, err := minio.New(endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(key, secret, ""),
-		Secure: useSSL,
-	})
+	opts, err := copycat.ProfileOptions(name, profile)
 	if err != nil {
 		return nil, err
 	}
 
-	return minioClient, nil
+	if keyFile := os.Getenv("COPYCAT_KEY_FILE"); keyFile != "" {
+		opts.KeyFile = keyFile
+	}
+	if os.Getenv("COPYCAT_ENCRYPT") == "1" {
+		opts.Encrypt = true
+	}
+	opts.Passphrase = readPassphrase
+
+	return copycat.New(opts)
 }
 
-// Checks whether configuration for a given profile exists. If so, it returns
-// the full path to the config file.
-func configExists(profile string) (string, bool) {
-	// Get user's home directory
-	home, err := os.UserHomeDir()
+// Passphrase entered at the prompt, so it is only asked for once per run. Kept
+// out of the process environment, so commands started by copycat never see it.
+var enteredPassphrase string
 
-	if err != nil {
-		log.Fatal(Fata("A fatal error occurred: %w"), err)
+// Returns COPYCAT_PASSPHRASE if set, otherwise prompts for it.
+func readPassphrase(confirm bool) (string, error) {
+	if passphrase := os.Getenv("COPYCAT_PASSPHRASE"); passphrase != "" {
+		return passphrase, nil
+	}
+	if enteredPassphrase != "" {
+		return enteredPassphrase, nil
 	}
 
-	config := home + "/.config/copycat/" + profile
-	if _, err := os.Stat(config); err == nil {
-		return config, true
-	} else {
-		log.Fatal(err)
+	if stdinConsumed {
+		tty, err := openTerminal()
+		if err != nil {
+			return "", errors.New("the passphrase cannot be prompted for, as stdin holds the data being uploaded: set COPYCAT_PASSPHRASE instead")
+		}
+		tty.Close()
 	}
-	return "", false
-}
 
-// Gets the "active" client, and returns a minioClient, the active bucket name
-// and, if successful, nil. Otherwise, the err will be set.
-func getClient() (*minio.Client, string, error) {
-	config, configExists := configExists(os.Getenv("COPYCAT_PROFILE"))
+	fmt.Print(Info("Passphrase: "))
+	passphrase := readSecret()
 
-	if !configExists {
-		fmt.Println("Configuration does not exist. Run " + Info("copycat configure") + " to create configuration file.")
-		os.Exit(1)
+	if passphrase == "" {
+		return "", errors.New("no passphrase given")
 	}
 
-	godotenv.Load(config)
+	if confirm {
+		fmt.Print(Info("Confirm passphrase: "))
 
-	client, err := createClient(os.Getenv("HOSTNAME"), os.Getenv("KEY"), os.Getenv("SECRET"))
-	if err != nil {
-		return nil, "", fmt.Errorf("error creating new client: %w", err)
+		if readSecret() != passphrase {
+			return "", errors.New("passphrases do not match")
+		}
 	}
 
-	return client, os.Getenv("BUCKET"), nil
+	enteredPassphrase = passphrase
+
+	return passphrase, nil
 }
 
 // Given a bucket name, ensure that the bucket exists. Can be modified to
@@ -103,7 +136,6 @@ func ensureBucket(minioClient *minio.Client, bucket string) error {
 	found, err := minioClient.BucketExists(context.Background(), bucket)
 
 	if err != nil {
-		log.Fatal(err)
 		return err
 	}
 
@@ -124,58 +156,169 @@ func ensureBucket(minioClient *minio.Client, bucket string) error {
 	return nil
 }
 
-// Given a host, it's access information, the bucket to be used, and a path
-// create a new configuration file in the given path. Returns nil if successful,
-// otherwise an error.
-func createConfig(host string, key string, secret string, bucket string, path string) error {
-	config := []byte("HOSTNAME=" + host + "\nKEY=" + key + "\nSECRET=" + secret + "\nBUCKET=" + bucket)
+// Helper function used to ensure that expected arguments are set, otherwise
+// returns a usage error.
+func requireArgs(args []string, count int, strict bool, files bool) error {
+	if (strict && len(args) != count) || len(args) < count {
+		return usage("Expected "+strconv.Itoa(count)+" argument(s), got "+strconv.Itoa(len(args)), files)
+	}
 
-	err := os.WriteFile(path, config, 0644)
+	return nil
+}
 
-	if err != nil {
-		return fmt.Errorf("error writing config file: %w", err)
+// Returns the environment given to a command (its only argument), or the
+// project manifest's or active profile's default environment if none is. An
+// invalid manifest is reported, like pull does, rather than skipped.
+func environmentArg(args []string) (string, error) {
+	if len(args) == 0 {
+		manifest, err := copycat.FindManifest(".")
+		if err != nil && !errors.Is(err, errNotFound) {
+			return "", err
+		}
+		if manifest.Environment != "" {
+			return manifest.Environment, nil
+		}
+
+		name, err := activeProfile()
+		if err != nil {
+			return "", err
+		}
+		if profile, err := copycat.ReadProfile(name); err == nil && profile.Environment != "" {
+			return profile.Environment, nil
+		}
 	}
 
-	return nil
+	// Without a default environment, the argument is required.
+	if err := requireArgs(args, 1, true, false); err != nil {
+		return "", err
+	}
+
+	return args[0], nil
 }
 
-// Wrapper function used for uploading files given it's storage name and the
-// path to store it in.
-func uploadFile(minioClient *minio.Client, objectName string, filePath string, contentType string, bucket string) error {
-	_, err := minioClient.FPutObject(context.Background(), bucket, objectName, filePath, minio.PutObjectOptions{ContentType: contentType})
+// Prompts the user to confirm an action, unless yes is already set. Returns
+// whether the action was confirmed.
+func confirm(prompt string, yes bool) bool {
+	if yes {
+		return true
+	}
+
+	fmt.Print(Warn(prompt + " [y/N]? "))
+	answer := strings.TrimSpace(readLine())
 
-	return err
+	return answer == "Y" || answer == "y"
 }
 
-// Helper function used to ensure that expected arguments are set, otherwise
-// terminates the program.
-func requireArgs(args []string, count int, strict bool, files bool) {
-	if (strict && len(args) != count) || len(args) < count {
-		fmt.Println(Warn("Expected " + strconv.Itoa(count) + " argument(s), got " + strconv.Itoa(len(args))))
-		help(files)
-		os.Exit(1)
+// Reads a single line from stdin, without its line ending. Unlike fmt.Scanln,
+// values containing spaces are kept whole. Stdin is read a byte at a time, so
+// nothing following the line is consumed.
+func readLine() string {
+	var line []byte
+	b := make([]byte, 1)
+
+	for {
+		if n, err := os.Stdin.Read(b); n == 0 || err != nil || b[0] == '\n' {
+			break
+		}
+		line = append(line, b[0])
 	}
+
+	return strings.TrimSuffix(string(line), "\r")
+}
+
+// Prints label, and returns the line entered in response, trimmed of
+// surrounding whitespace.
+func prompt(label string) string {
+	fmt.Print(Info(label))
+
+	return strings.TrimSpace(readLine())
+}
+
+// Set once stdin was read as data (i.e. by upload --file -), so secrets are
+// then read from the terminal instead.
+var stdinConsumed bool
+
+// Opens the controlling terminal, to prompt for secrets once stdin was
+// consumed.
+func openTerminal() (*os.File, error) {
+	return os.OpenFile("/dev/tty", os.O_RDWR, 0)
 }
 
-// Get's the version of the uploaded binary, and returns that. If successful,
-// the version will be returned alongside a nil error value. Otherwise, err
-// will be set.
-func getVersion() (string, error) {
-	resp, err := http.Get(os.Getenv(("VERSION_LOG")))
+// Reads a single line from stdin like readLine, without echoing it if stdin is
+// a terminal. Once stdin was consumed, the line is read from the terminal.
+func readSecret() string {
+	in := os.Stdin
+	if stdinConsumed {
+		tty, err := openTerminal()
+		if err != nil {
+			return ""
+		}
+		defer tty.Close()
+		in = tty
+	}
 
+	fd := int(in.Fd())
+	if !term.IsTerminal(fd) {
+		return readLine()
+	}
+
+	data, err := term.ReadPassword(fd)
+	fmt.Println()
 	if err != nil {
-		return "", fmt.Errorf("HTTP error: %w", err)
+		return ""
 	}
 
-	response, err := io.ReadAll(resp.Body)
+	return string(data)
+}
+
+// Prints label, and returns the secret entered in response (without echoing
+// it), trimmed of surrounding whitespace.
+func promptSecret(label string) string {
+	fmt.Print(Info(label))
+
+	return strings.TrimSpace(readSecret())
+}
 
-	// Ensure that file fetched actually has a version tag in it.
-	if err != nil || response[0] != 118 {
-		return "", errors.New("file not fetched properly")
+// Returns value if set, otherwise the given environment variable.
+func flagOrEnv(value string, name string) string {
+	if value != "" {
+		return value
 	}
 
-	// See if string starts with a "v" for legacy reasons.
-	hostVersion := string(response[:len(response)-1])
+	return os.Getenv(name)
+}
 
-	return hostVersion, nil
+// Parses flags appearing anywhere among args (i.e. "diff staging --show-values"
+// as well as "diff --show-values staging"), returning the positional arguments.
+// Everything following a "--" terminator is returned as-is. Unknown flags and
+// invalid values are returned as usage errors rather than terminating the
+// program, so they are reported like any other error (i.e. in JSON output).
+func parseFlags(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+
+	flags.SetOutput(io.Discard)
+
+	for {
+		if err := flags.Parse(args); errors.Is(err, flag.ErrHelp) {
+			if err = showHelp(helpTopic(flags.Name())); err != nil {
+				return nil, err
+			}
+			return nil, exitStatus(0)
+		} else if err != nil {
+			return nil, topicUsage(helpTopic(flags.Name()), err.Error())
+		}
+		remaining := flags.Args()
+
+		// Parsing stopped at a "--" terminator.
+		if len(remaining) < len(args) && args[len(args)-len(remaining)-1] == "--" {
+			return append(positional, remaining...), nil
+		}
+
+		if len(remaining) == 0 {
+			return positional, nil
+		}
+
+		positional = append(positional, remaining[0])
+		args = remaining[1:]
+	}
 }
@@ -3,26 +3,37 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/pem"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"ghst.fr/matthew/copy-cat-env/copycat"
 	"github.com/joho/godotenv"
 	"github.com/minio/minio-go/v7"
 )
 
-// TODO: Write test cases.
-
 func TestCreateClient(t *testing.T) {
+	// Fall back to an in-memory server when no live bucket is configured.
 	err := godotenv.Load()
 	if err != nil && os.Getenv("DUMMY_HOST") == "" {
-		t.Errorf(Fata("Error loading .env file"))
-		return
+		fake := newFakeS3(t)
+		t.Setenv("DUMMY_HOST", fake.URL)
+		t.Setenv("DUMMY_KEY", "key")
+		t.Setenv("DUMMY_SECRET", "secret")
+		t.Setenv("DUMMY_BUCKET", "dummy")
 	}
 
 	var dummyFile string = "dummy_file"
 
 	// Start by creating dummy client
-	client, err := createClient(os.Getenv("DUMMY_HOST"), os.Getenv("DUMMY_KEY"), os.Getenv("DUMMY_SECRET"))
+	client, err := copycat.NewS3Client(copycat.Options{
+		Host:   os.Getenv("DUMMY_HOST"),
+		Key:    os.Getenv("DUMMY_KEY"),
+		Secret: os.Getenv("DUMMY_SECRET"),
+		Region: os.Getenv("DUMMY_REGION"),
+	})
 	if err != nil || client == nil {
 		t.Errorf("Failed connecting to dummy client\n")
 		return
@@ -76,3 +87,115 @@ func TestCreateClient(t *testing.T) {
 		return
 	}
 }
+
+func TestCredentials(t *testing.T) {
+	credentialsFile := filepath.Join(t.TempDir(), "credentials")
+	writeFile(t, credentialsFile, `[static]
+aws_access_key_id = filekey
+aws_secret_access_key = filesecret
+
+[process]
+credential_process = echo {"Version":1,"AccessKeyId":"processkey","SecretAccessKey":"secret","SessionToken":"processtoken"}
+`)
+
+	tests := []struct {
+		profile copycat.Profile
+		env     map[string]string
+		key     string
+		token   string
+	}{
+		{copycat.Profile{Key: "statickey", Secret: "secret"}, nil, "statickey", ""},
+		{
+			copycat.Profile{Credentials: "env"},
+			map[string]string{"AWS_ACCESS_KEY_ID": "envkey", "AWS_SECRET_ACCESS_KEY": "secret", "AWS_SESSION_TOKEN": "envtoken"},
+			"envkey", "envtoken",
+		},
+		{copycat.Profile{Credentials: "file", CredentialsFile: credentialsFile, CredentialsProfile: "static"}, nil, "filekey", ""},
+		{copycat.Profile{Credentials: "file", CredentialsFile: credentialsFile, CredentialsProfile: "process"}, nil, "processkey", "processtoken"},
+		// Without any environment variable, the chain falls through to the file.
+		{copycat.Profile{Credentials: "chain", CredentialsFile: credentialsFile, CredentialsProfile: "static"}, nil, "filekey", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.key, func(t *testing.T) {
+			for _, name := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN"} {
+				t.Setenv(name, test.env[name])
+			}
+
+			fake := newFakeS3(t, testBucket)
+			test.profile.Host = fake.URL
+			test.profile.Bucket = testBucket
+			setupProfile(t, test.profile)
+
+			writeFile(t, ".env", "KEY=value\n")
+			captureOutput(t, "", func() error { return upload([]string{"staging"}) })
+
+			if key, token := fake.credentials(); key != test.key || token != test.token {
+				t.Errorf("Expected requests signed by %s (token %q), got %s (token %q)", test.key, test.token, key, token)
+			}
+		})
+	}
+}
+
+func TestConnectionSettings(t *testing.T) {
+	proxy := newFakeS3(t, testBucket)
+	secure := newFakeS3TLS(t, testBucket)
+
+	caBundle := filepath.Join(t.TempDir(), "ca.pem")
+	writeFile(t, caBundle, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: secure.Certificate().Raw})))
+
+	tests := map[string]struct {
+		fake    *fakeS3
+		profile copycat.Profile
+		host    string
+	}{
+		"path":      {proxy, copycat.Profile{Host: "http://s3.test", Proxy: proxy.URL, BucketLookup: "path"}, "s3.test"},
+		"dns":       {proxy, copycat.Profile{Host: "http://s3.test", Proxy: proxy.URL, BucketLookup: "dns"}, testBucket + ".s3.test"},
+		"ca bundle": {secure, copycat.Profile{Host: secure.URL, CABundle: caBundle, RequestTimeout: 5 * time.Second, ConnectTimeout: 5 * time.Second}, ""},
+		"insecure":  {secure, copycat.Profile{Host: secure.URL, InsecureSkipVerify: true}, ""},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			test.profile.Key, test.profile.Secret = "key", "secret"
+			test.profile.Bucket = testBucket
+			setupProfile(t, test.profile)
+
+			writeFile(t, ".env", "KEY="+name+"\n")
+			captureOutput(t, "", func() error { return upload([]string{"staging"}) })
+
+			if data, _ := test.fake.object(testBucket, "env_staging"); string(data) != "KEY="+name+"\n" {
+				t.Errorf("Unexpected environment uploaded: %q", data)
+			}
+			if host := test.fake.lastHost(); test.host != "" && host != test.host {
+				t.Errorf("Expected requests for %s, got %s", test.host, host)
+			}
+		})
+	}
+
+	// The server's certificate is not trusted without the CA bundle.
+	profile := copycat.Profile{Host: secure.URL, Key: "key", Secret: "secret", Bucket: testBucket}
+	setupProfile(t, profile)
+	writeFile(t, ".env", "KEY=value\n")
+	if _, err := captureError(t, "", func() error { return upload([]string{"staging"}) }); err == nil {
+		t.Error("Expected an untrusted certificate to be refused")
+	}
+
+	invalid := map[string]func(*copycat.Profile){
+		"bucket lookup": func(p *copycat.Profile) { p.BucketLookup = "virtual" },
+		"CA bundle":     func(p *copycat.Profile) { p.CABundle = caBundle + ".missing" },
+		"proxy":         func(p *copycat.Profile) { p.Proxy = "http://[::1" },
+	}
+	for name, change := range invalid {
+		invalidProfile := profile
+		change(&invalidProfile)
+
+		opts, err := copycat.ProfileOptions("default", invalidProfile)
+		if err == nil {
+			_, err = copycat.NewS3Client(opts)
+		}
+		if err == nil {
+			t.Errorf("Expected an invalid %s to be refused", name)
+		}
+	}
+}